@@ -0,0 +1,25 @@
+package expressTrace
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+// CallbackFunc 接收标准化后的推送结果，返回error时回调处理器会告知服务商推送失败
+type CallbackFunc func(context.Context, *SubscribeRes) error
+
+// ParseCallback 解析推送请求的orderId(url参数)与表单参数，orderId缺失或格式错误时返回0
+func ParseCallback(r *http.Request) (int64, map[string]string, error) {
+	if err := r.ParseForm(); err != nil {
+		return 0, nil, err
+	}
+	var data = make(map[string]string)
+	for k, v := range r.Form {
+		if len(v) > 0 {
+			data[k] = v[0]
+		}
+	}
+	orderId, _ := strconv.ParseInt(r.URL.Query().Get("orderId"), 10, 64)
+	return orderId, data, nil
+}
@@ -0,0 +1,547 @@
+package expressTrace
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-tron/logger"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultServerAddr        = ":8080"
+	DefaultWebhookTimeout    = 10 * time.Second
+	DefaultWebhookRetries    = 3
+	DefaultRetryWait         = time.Second
+	DefaultShutdownTimeout   = 10 * time.Second
+	DefaultDrainDelay        = 5 * time.Second
+	DefaultRedeliverInterval = time.Minute
+	MaxRedeliverInterval     = time.Hour
+
+	HealthPath = "/healthz"
+	ReadyPath  = "/readyz"
+
+	SignatureHeader = "X-Express-Trace-Signature"
+)
+
+// CallbackProvider 提供推送处理器的服务商，fuqing.Fuqing 与 kuaidi100.Kuaidi100 均已实现
+type CallbackProvider interface {
+	CallbackHandler(fn CallbackFunc) http.Handler
+}
+
+// Resubscriber 订阅中止后可重新订阅的服务商，如 kuaidi100.Kuaidi100。
+// CallbackServer 在推送保存后以 Store 中的运单调用，订阅成功时在 Store 中记录 Resubscribes
+type Resubscriber interface {
+	Resubscribe(ctx context.Context, res *SubscribeRes) (bool, error)
+}
+
+// Webhook 接收标准化推送结果的下游地址
+type Webhook struct {
+	Url    string `json:"url"`
+	Secret string `json:"secret"` //不为空时以HMAC-SHA256签名请求体，放在 SignatureHeader 请求头
+}
+
+// CallbackServer 托管各服务商的推送地址，验证并标准化推送后合并到 Store，
+// 运单有新轨迹或状态变化时将 SubscribeRes 以JSON POST到全部 Webhooks。
+// webhook 在确认推送前写入 Outbox，同一运单同一webhook按 Sequence 顺序发送，未发送的旧版本被新版本替换
+type CallbackServer struct {
+	Addr              string                      //监听地址，默认 DefaultServerAddr
+	Routes            map[string]CallbackProvider //推送路径对应的服务商，如 "/fuqing"，与各服务商 SubscribeUrl 的路径一致
+	Store             Store
+	Webhooks          []Webhook
+	Outbox            Outbox        //默认 MemoryOutbox，使用 FileOutbox 等持久化实现时进程重启后继续发送
+	Client            *http.Client  //发送webhook，默认超时 DefaultWebhookTimeout
+	Retries           int           //webhook失败后的重试次数，默认 DefaultWebhookRetries，小于0时不重试
+	RetryWait         time.Duration //首次重试等待时间，之后每次加倍，默认 DefaultRetryWait
+	ShutdownTimeout   time.Duration //停止时等待处理中请求与webhook的最长时间，默认 DefaultShutdownTimeout
+	DrainDelay        time.Duration //停止时先使 ReadyPath 返回503，等待该时间供负载均衡摘除流量后再停止接收请求，默认 DefaultDrainDelay，小于0时不等待
+	RedeliverInterval time.Duration //扫描 Outbox 重新发送失败webhook的间隔，同一条目每次失败后等待时间加倍，最长 MaxRedeliverInterval，默认 DefaultRedeliverInterval，小于0时不扫描
+	Clock             Clock         //默认 SystemClock
+	Logger            logger.Logger
+	wg                sync.WaitGroup
+	mu                sync.Mutex
+	resubscribeMu     sync.Mutex
+	pending           map[string]*OutboxEntry //等待发送的最新条目
+	running           map[string]bool         //正在发送的运单与webhook
+	failed            map[string]*redelivery  //发送失败等待重新发送的运单与webhook
+	redeliverOnce     sync.Once
+	closing           int32
+	stopped           bool //Shutdown 开始后不再发送新的webhook，条目保留在 Outbox 中
+	ctx               context.Context
+	cancel            context.CancelFunc
+}
+
+func NewCallbackServer(c *CallbackServer) *CallbackServer {
+	if c == nil {
+		panic("config 必须设置")
+	}
+	if len(c.Routes) == 0 {
+		panic("Routes 必须设置")
+	}
+	if c.Store == nil {
+		panic("Store 必须设置")
+	}
+	if c.Logger == nil {
+		panic("Logger 必须设置")
+	}
+	for _, w := range c.Webhooks {
+		if w.Url == "" {
+			panic("Webhook Url 必须设置")
+		}
+	}
+	if c.Addr == "" {
+		c.Addr = DefaultServerAddr
+	}
+	if c.Client == nil {
+		c.Client = &http.Client{Timeout: DefaultWebhookTimeout}
+	}
+	if c.Retries == 0 {
+		c.Retries = DefaultWebhookRetries
+	}
+	if c.RetryWait == 0 {
+		c.RetryWait = DefaultRetryWait
+	}
+	if c.ShutdownTimeout == 0 {
+		c.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if c.DrainDelay == 0 {
+		c.DrainDelay = DefaultDrainDelay
+	}
+	if c.RedeliverInterval == 0 {
+		c.RedeliverInterval = DefaultRedeliverInterval
+	}
+	if c.Clock == nil {
+		c.Clock = SystemClock
+	}
+	if c.Outbox == nil {
+		c.Outbox = NewMemoryOutbox()
+	}
+	c.pending = make(map[string]*OutboxEntry)
+	c.running = make(map[string]bool)
+	c.failed = make(map[string]*redelivery)
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c
+}
+
+// Handler 返回推送路由与 HealthPath、ReadyPath，可挂载到已有的http服务，此时应先调用 Resume
+func (s *CallbackServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	for path, provider := range s.Routes {
+		mux.Handle(path, provider.CallbackHandler(s.callback(provider)))
+	}
+	mux.HandleFunc(HealthPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc(ReadyPath, func(w http.ResponseWriter, r *http.Request) {
+		if err := s.Check(r.Context()); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	return mux
+}
+
+// Ready 开始停止后返回false，负载均衡据此摘除流量
+func (s *CallbackServer) Ready() bool {
+	return atomic.LoadInt32(&s.closing) == 0
+}
+
+// Check 开始停止或 Store 不可用时返回错误，Store 实现 Pinger 时使用 Ping，否则使用 Load
+func (s *CallbackServer) Check(ctx context.Context) error {
+	if !s.Ready() {
+		return errors.New("shutting down")
+	}
+	var err error
+	if pinger, ok := s.Store.(Pinger); ok {
+		err = pinger.Ping(ctx)
+	} else {
+		_, err = s.Store.Load(ctx, 0)
+	}
+	if err != nil {
+		return fmt.Errorf("store: %v", err)
+	}
+	return nil
+}
+
+// Run 监听 Addr 直到ctx结束，随后优雅停止
+func (s *CallbackServer) Run(ctx context.Context) error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, l)
+}
+
+// Resume 继续发送 Outbox 中上次未发送成功的webhook，并开始按 RedeliverInterval 重新发送失败的webhook
+func (s *CallbackServer) Resume(ctx context.Context) error {
+	list, err := s.Outbox.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, e := range list {
+		s.enqueue(e)
+	}
+	if s.RedeliverInterval > 0 {
+		s.redeliverOnce.Do(func() {
+			go s.redeliverLoop()
+		})
+	}
+	return nil
+}
+
+// Serve 在l上提供服务直到ctx结束。停止时先使 ReadyPath 返回503并等待 DrainDelay，
+// 再停止接收新请求并等待处理中的推送与webhook，最长 ShutdownTimeout
+func (s *CallbackServer) Serve(ctx context.Context, l net.Listener) error {
+	if err := s.Resume(ctx); err != nil {
+		l.Close()
+		return err
+	}
+	srv := &http.Server{Handler: s.Handler()}
+	var errCh = make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(l)
+	}()
+	s.Logger.Info("推送服务已启动", s.Logger.Field("addr", l.Addr().String()))
+
+	var serveErr error
+	select {
+	case serveErr = <-errCh:
+	case <-ctx.Done():
+	}
+
+	atomic.StoreInt32(&s.closing, 1)
+	if serveErr == nil && s.DrainDelay > 0 {
+		s.Logger.Info("等待摘除流量", s.Logger.Field("drainDelay", s.DrainDelay.String()))
+		select {
+		case serveErr = <-errCh:
+		case <-s.Clock.After(s.DrainDelay):
+		}
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+	defer cancel()
+	if serveErr != nil {
+		s.Shutdown(shutdownCtx)
+		return serveErr
+	}
+	err := srv.Shutdown(shutdownCtx)
+	if e := s.Shutdown(shutdownCtx); err == nil {
+		err = e
+	}
+	s.Logger.Info("推送服务已停止")
+	return err
+}
+
+// Shutdown 等待已开始的webhook发送完成，ctx结束时取消剩余的发送与重试。
+// 开始停止后写入 Outbox 的webhook不再发送，由下次启动的 Resume 发送
+func (s *CallbackServer) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&s.closing, 1)
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+	var done = make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// callback 服务商实现 Resubscriber 时在推送保存后处理订阅中止
+func (s *CallbackServer) callback(provider CallbackProvider) CallbackFunc {
+	resubscriber, ok := provider.(Resubscriber)
+	if !ok {
+		return s.handle
+	}
+	return func(ctx context.Context, res *SubscribeRes) error {
+		if err := s.handle(ctx, res); err != nil {
+			return err
+		}
+		if res.Subscription == SubscriptionAbort {
+			s.resubscribe(ctx, resubscriber, res)
+		}
+		return nil
+	}
+}
+
+// resubscribe 同一次中止只重新订阅一次，重新订阅的次数记录在 Store 中，重推或重启后不会重复订阅
+func (s *CallbackServer) resubscribe(ctx context.Context, p Resubscriber, res *SubscribeRes) {
+	s.resubscribeMu.Lock()
+	defer s.resubscribeMu.Unlock()
+	current, err := load(ctx, s.Store, res.OrderId, res.Number)
+	if err != nil || current == nil || current.Resubscribed {
+		return
+	}
+	ok, err := p.Resubscribe(ctx, current)
+	if err != nil {
+		s.Logger.Error("重新订阅失败",
+			s.Logger.Field("orderId", current.OrderId),
+			s.Logger.Field("number", current.Number),
+			s.Logger.Field("error", err),
+		)
+		return
+	}
+	if !ok {
+		return
+	}
+	err = update(ctx, s.Store, current.OrderId, "", func(old *SubscribeRes) (*SubscribeRes, error) {
+		if old == nil {
+			return nil, ErrorSubscription(current.Number)
+		}
+		old.Resubscribes++
+		old.Resubscribed = true
+		return old, nil
+	})
+	if err != nil {
+		s.Logger.Error("重新订阅次数保存失败",
+			s.Logger.Field("orderId", current.OrderId),
+			s.Logger.Field("error", err),
+		)
+	}
+}
+
+// handle 合并推送结果并将webhook写入 Outbox 后再确认推送，任一步失败时返回error使服务商重推。
+// 运单的 Notified 小于 Sequence 时说明上次合并后未写入 Outbox(写入失败或进程中断)，重推时即使没有变化也会再次写入
+func (s *CallbackServer) handle(ctx context.Context, res *SubscribeRes) error {
+	change, err := Merge(ctx, s.Store, res)
+	if err != nil {
+		return err
+	}
+	shipment := change.Shipment
+	if len(s.Webhooks) == 0 || shipment.Notified >= shipment.Sequence {
+		return nil
+	}
+
+	body, err := json.Marshal(shipment)
+	if err != nil {
+		return err
+	}
+	var entries = make([]*OutboxEntry, 0, len(s.Webhooks))
+	for _, w := range s.Webhooks {
+		e := &OutboxEntry{
+			Url:      w.Url,
+			OrderId:  shipment.OrderId,
+			Sequence: shipment.Sequence,
+			Body:     body,
+		}
+		if err := s.Outbox.Put(ctx, e); err != nil {
+			return err
+		}
+		entries = append(entries, e)
+	}
+	err = update(ctx, s.Store, shipment.OrderId, "", func(old *SubscribeRes) (*SubscribeRes, error) {
+		if old == nil {
+			return nil, ErrorSubscription(shipment.Number)
+		}
+		if old.Notified < shipment.Sequence {
+			old.Notified = shipment.Sequence
+		}
+		return old, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		s.enqueue(e)
+	}
+	return nil
+}
+
+// enqueue 同一运单同一webhook只有一个发送协程，发送中到达的新版本替换未发送的旧版本。
+// Shutdown 开始后不再启动发送协程，避免 wg.Wait 期间调用 wg.Add
+func (s *CallbackServer) enqueue(e *OutboxEntry) {
+	key := e.key()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	if old, ok := s.pending[key]; ok && old.Sequence > e.Sequence {
+		return
+	}
+	s.pending[key] = e
+	if s.running[key] {
+		return
+	}
+	s.running[key] = true
+	s.wg.Add(1)
+	go s.deliver(key)
+}
+
+func (s *CallbackServer) deliver(key string) {
+	defer s.wg.Done()
+	for {
+		s.mu.Lock()
+		e, ok := s.pending[key]
+		if !ok {
+			delete(s.running, key)
+			s.mu.Unlock()
+			return
+		}
+		delete(s.pending, key)
+		s.mu.Unlock()
+		ok = s.send(e)
+
+		s.mu.Lock()
+		if ok {
+			delete(s.failed, key)
+		} else if s.RedeliverInterval > 0 {
+			s.failed[key] = s.failed[key].next(s.Clock.Now(), s.RedeliverInterval)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// redelivery 发送失败的次数与下次重新发送的时间
+type redelivery struct {
+	failures int
+	at       time.Time
+}
+
+// next 失败后等待 interval，之后每次失败加倍，最长 MaxRedeliverInterval
+func (r *redelivery) next(now time.Time, interval time.Duration) *redelivery {
+	var failures = 1
+	if r != nil {
+		failures = r.failures + 1
+	}
+	wait := interval
+	for i := 1; i < failures && wait < MaxRedeliverInterval; i++ {
+		wait *= 2
+	}
+	if wait > MaxRedeliverInterval {
+		wait = MaxRedeliverInterval
+	}
+	return &redelivery{failures: failures, at: now.Add(wait)}
+}
+
+// redeliverLoop 每隔 RedeliverInterval 重新发送 Outbox 中已到重试时间的失败条目，直到 Shutdown
+func (s *CallbackServer) redeliverLoop() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.Clock.After(s.RedeliverInterval):
+		}
+		if err := s.redeliver(s.ctx); err != nil {
+			s.Logger.Error("读取Outbox失败", s.Logger.Field("error", err))
+		}
+	}
+}
+
+// redeliver 只重新发送记录了失败的条目，未发送过的条目由 handle 或 Resume 发送
+func (s *CallbackServer) redeliver(ctx context.Context) error {
+	list, err := s.Outbox.List(ctx)
+	if err != nil {
+		return err
+	}
+	now := s.Clock.Now()
+	for _, e := range list {
+		key := e.key()
+		s.mu.Lock()
+		r, ok := s.failed[key]
+		due := ok && !r.at.After(now) && !s.running[key]
+		s.mu.Unlock()
+		if due {
+			s.enqueue(e)
+		}
+	}
+	return nil
+}
+
+// send 发送成功或webhook已不在配置中时从 Outbox 删除并返回true，失败时保留在 Outbox 中由 redeliverLoop 重新发送
+func (s *CallbackServer) send(e *OutboxEntry) bool {
+	var webhook *Webhook
+	for i := range s.Webhooks {
+		if s.Webhooks[i].Url == e.Url {
+			webhook = &s.Webhooks[i]
+			break
+		}
+	}
+	if webhook != nil {
+		if err := s.forward(s.ctx, *webhook, e.Body); err != nil {
+			s.Logger.Error("webhook发送失败",
+				s.Logger.Field("url", e.Url),
+				s.Logger.Field("orderId", e.OrderId),
+				s.Logger.Field("sequence", e.Sequence),
+				s.Logger.Field("error", err),
+			)
+			return false
+		}
+	}
+	if err := s.Outbox.Delete(context.Background(), e); err != nil {
+		s.Logger.Error("webhook删除失败",
+			s.Logger.Field("url", e.Url),
+			s.Logger.Field("orderId", e.OrderId),
+			s.Logger.Field("error", err),
+		)
+	}
+	return true
+}
+
+// forward 发送webhook，连接失败、429或5xx时按 RetryWait 加倍等待后重试
+func (s *CallbackServer) forward(ctx context.Context, w Webhook, body []byte) error {
+	wait := s.RetryWait
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(ctx, w, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= s.Retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-s.Clock.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func (s *CallbackServer) post(ctx context.Context, w Webhook, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.Url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if w.Secret != "" {
+		req.Header.Set(SignatureHeader, Signature(w.Secret, body))
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return !errors.Is(err, context.Canceled), err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook返回状态码 %d", resp.StatusCode)
+}
+
+// Signature webhook请求体的HMAC-SHA256签名，下游用于验证请求来源
+func Signature(secret string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,537 @@
+package expressTrace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-tron/logger"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// jsonProvider 推送请求体即为 SubscribeRes 的服务商
+type jsonProvider struct{}
+
+func (jsonProvider) CallbackHandler(fn CallbackFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var res SubscribeRes
+		if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := fn(r.Context(), &res); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("success"))
+	})
+}
+
+type webhookRecorder struct {
+	mu       sync.Mutex
+	failures int //前几次请求返回503，小于0时全部返回503
+	calls    int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (h *webhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calls++
+	if h.failures < 0 || h.calls <= h.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	h.bodies = append(h.bodies, body)
+	h.headers = append(h.headers, r.Header.Clone())
+}
+
+func (h *webhookRecorder) received() ([][]byte, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.bodies, h.calls
+}
+
+func (h *webhookRecorder) setFailures(failures int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures = failures
+}
+
+func newCallbackServer(store Store, webhooks ...Webhook) *CallbackServer {
+	return newCallbackServerWithOutbox(store, nil, webhooks...)
+}
+
+func newCallbackServerWithOutbox(store Store, outbox Outbox, webhooks ...Webhook) *CallbackServer {
+	return NewCallbackServer(&CallbackServer{
+		Routes:   map[string]CallbackProvider{"/push": jsonProvider{}},
+		Store:    store,
+		Webhooks: webhooks,
+		Outbox:   outbox,
+		//fakeClock.After 立即返回，不扫描 Outbox 以免空转
+		RedeliverInterval: -1,
+		Clock:             &fakeClock{now: time.Now()},
+		Logger:            logger.NewZap("callbackServer", "error"),
+	})
+}
+
+func push(t *testing.T, handler http.Handler, res *SubscribeRes) int {
+	body, _ := json.Marshal(res)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/push", bytes.NewReader(body)))
+	return w.Code
+}
+
+func TestCallbackServer(t *testing.T) {
+	hook := &webhookRecorder{failures: 2}
+	downstream := httptest.NewServer(hook)
+	defer downstream.Close()
+
+	store := NewMemoryStore()
+	s := newCallbackServer(store, Webhook{Url: downstream.URL, Secret: "123"})
+	handler := s.Handler()
+
+	res := &SubscribeRes{
+		OrderId: 33333,
+		Number:  "JD0076810060555",
+		Status:  StatusInTransit,
+		Traces:  []Trace{trace(t, "2022-06-29 22:28:45", "您的快件在【西安灞桥分拣中心】分拣完成")},
+	}
+	if code := push(t, handler, res); code != http.StatusOK {
+		t.Fatalf("push: %d", code)
+	}
+	//重复推送没有变化，不再转发
+	if code := push(t, handler, res); code != http.StatusOK {
+		t.Fatalf("push: %d", code)
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, _ := store.Load(context.Background(), 33333)
+	if saved == nil || saved.Status != StatusInTransit {
+		t.Fatalf("not saved: %+v", saved)
+	}
+	bodies, calls := hook.received()
+	if len(bodies) != 1 || calls != 3 {
+		t.Fatalf("webhook bodies %d, calls %d", len(bodies), calls)
+	}
+	if sign := hook.headers[0].Get(SignatureHeader); sign != Signature("123", bodies[0]) {
+		t.Errorf("unexpected signature %s", sign)
+	}
+	var forwarded SubscribeRes
+	if err := json.Unmarshal(bodies[0], &forwarded); err != nil {
+		t.Fatal(err)
+	}
+	if forwarded.OrderId != 33333 || len(forwarded.Traces) != 1 {
+		t.Errorf("unexpected webhook body %+v", forwarded)
+	}
+}
+
+// TestCallbackServer_Order 同一运单连续推送时webhook按 Sequence 递增的顺序到达
+func TestCallbackServer_Order(t *testing.T) {
+	hook := &webhookRecorder{failures: 2}
+	downstream := httptest.NewServer(hook)
+	defer downstream.Close()
+
+	s := newCallbackServer(NewMemoryStore(), Webhook{Url: downstream.URL})
+	handler := s.Handler()
+	res := &SubscribeRes{OrderId: 33333, Number: "JD0076810060555", Status: StatusInTransit}
+	for i := 0; i < 10; i++ {
+		res.Traces = append(res.Traces, trace(t, fmt.Sprintf("2022-06-29 %02d:00:00", i+1), fmt.Sprintf("轨迹%d", i)))
+		if code := push(t, handler, res); code != http.StatusOK {
+			t.Fatalf("push: %d", code)
+		}
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies, _ := hook.received()
+	var last int64
+	for _, body := range bodies {
+		var forwarded SubscribeRes
+		if err := json.Unmarshal(body, &forwarded); err != nil {
+			t.Fatal(err)
+		}
+		if forwarded.Sequence <= last {
+			t.Fatalf("sequence %d after %d", forwarded.Sequence, last)
+		}
+		last = forwarded.Sequence
+	}
+	if last != 10 {
+		t.Errorf("last sequence %d", last)
+	}
+	if list, _ := s.Outbox.List(context.Background()); len(list) != 0 {
+		t.Errorf("outbox not empty: %d", len(list))
+	}
+}
+
+// TestCallbackServer_Outbox 发送失败的webhook保存在 FileOutbox 中，重启后由 Resume 继续发送
+func TestCallbackServer_Outbox(t *testing.T) {
+	hook := &webhookRecorder{failures: -1}
+	downstream := httptest.NewServer(hook)
+	defer downstream.Close()
+
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	outbox, err := NewFileOutbox(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryStore()
+	s := newCallbackServerWithOutbox(store, outbox, Webhook{Url: downstream.URL})
+	s.Retries = -1
+	if code := push(t, s.Handler(), &SubscribeRes{OrderId: 33333, Number: "JD0076810060555", Status: StatusInTransit}); code != http.StatusOK {
+		t.Fatalf("push: %d", code)
+	}
+	s.Shutdown(context.Background())
+
+	hook.setFailures(0)
+	reopened, err := NewFileOutbox(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := reopened.List(context.Background()); len(list) != 1 || list[0].OrderId != 33333 || list[0].Sequence != 1 {
+		t.Fatalf("unexpected outbox %+v", list)
+	}
+	s = newCallbackServerWithOutbox(store, reopened, Webhook{Url: downstream.URL})
+	if err := s.Resume(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.Shutdown(context.Background())
+	if bodies, _ := hook.received(); len(bodies) != 1 {
+		t.Fatalf("webhook bodies %d", len(bodies))
+	}
+	if list, _ := reopened.List(context.Background()); len(list) != 0 {
+		t.Errorf("outbox not empty: %d", len(list))
+	}
+}
+
+// failingOutbox fail 为true时 Put 返回错误的 Outbox
+type failingOutbox struct {
+	*MemoryOutbox
+	fail bool
+}
+
+func (o *failingOutbox) Put(ctx context.Context, e *OutboxEntry) error {
+	if o.fail {
+		return errors.New("disk full")
+	}
+	return o.MemoryOutbox.Put(ctx, e)
+}
+
+// TestCallbackServer_OutboxFailure 写入 Outbox 失败时推送返回错误，服务商重推相同内容时重新写入并发送
+func TestCallbackServer_OutboxFailure(t *testing.T) {
+	hook := &webhookRecorder{}
+	downstream := httptest.NewServer(hook)
+	defer downstream.Close()
+
+	store := NewMemoryStore()
+	outbox := &failingOutbox{MemoryOutbox: NewMemoryOutbox(), fail: true}
+	s := newCallbackServerWithOutbox(store, outbox, Webhook{Url: downstream.URL})
+	res := &SubscribeRes{OrderId: 33333, Number: "JD0076810060555", Status: StatusInTransit}
+	if code := push(t, s.Handler(), res); code != http.StatusInternalServerError {
+		t.Fatalf("push with failing outbox: %d", code)
+	}
+
+	outbox.fail = false
+	if code := push(t, s.Handler(), res); code != http.StatusOK {
+		t.Fatalf("retry push: %d", code)
+	}
+	s.Shutdown(context.Background())
+	bodies, _ := hook.received()
+	if len(bodies) != 1 {
+		t.Fatalf("webhook bodies %d", len(bodies))
+	}
+	if saved, _ := store.Load(context.Background(), 33333); saved == nil || saved.Notified != 1 {
+		t.Fatalf("unexpected shipment %+v", saved)
+	}
+
+	//已写入 Outbox 后的重推不再发送
+	s = newCallbackServerWithOutbox(store, outbox, Webhook{Url: downstream.URL})
+	if code := push(t, s.Handler(), res); code != http.StatusOK {
+		t.Fatalf("duplicate push: %d", code)
+	}
+	s.Shutdown(context.Background())
+	if bodies, _ := hook.received(); len(bodies) != 1 {
+		t.Fatalf("webhook bodies %d after duplicate push", len(bodies))
+	}
+}
+
+// resubscribeProvider 订阅中止时重新订阅的服务商，最多 limit 次
+type resubscribeProvider struct {
+	jsonProvider
+	limit int
+	calls int
+}
+
+func (p *resubscribeProvider) Resubscribe(ctx context.Context, res *SubscribeRes) (bool, error) {
+	if res.Resubscribes >= p.limit {
+		return false, nil
+	}
+	p.calls++
+	return true, nil
+}
+
+// TestCallbackServer_Resubscribe 同一次中止的重推只重新订阅一次，次数记录在 Store 中
+func TestCallbackServer_Resubscribe(t *testing.T) {
+	provider := &resubscribeProvider{limit: 2}
+	store := NewMemoryStore()
+	s := NewCallbackServer(&CallbackServer{
+		Routes: map[string]CallbackProvider{"/push": provider},
+		Store:  store,
+		Clock:  &fakeClock{now: time.Now()},
+		Logger: logger.NewZap("callbackServer", "error"),
+	})
+	handler := s.Handler()
+	abort := &SubscribeRes{OrderId: 33333, Number: "JD0076810060555", Status: StatusPending, Subscription: SubscriptionAbort}
+	polling := &SubscribeRes{OrderId: 33333, Number: "JD0076810060555", Status: StatusPending, Subscription: SubscriptionPolling}
+	for _, res := range []*SubscribeRes{abort, abort, polling, abort, polling, abort} {
+		if code := push(t, handler, res); code != http.StatusOK {
+			t.Fatalf("push: %d", code)
+		}
+	}
+	saved, _ := store.Load(context.Background(), 33333)
+	if provider.calls != 2 || saved.Resubscribes != 2 {
+		t.Fatalf("calls %d, saved %+v", provider.calls, saved)
+	}
+}
+
+func TestCallbackServer_Unsubscribed(t *testing.T) {
+	s := newCallbackServer(NewMemoryStore())
+	//未订阅的运单无法关联orderId，返回失败使服务商重推
+	if code := push(t, s.Handler(), &SubscribeRes{Number: "JD0076810060555", Status: StatusInTransit}); code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", code)
+	}
+}
+
+func TestCallbackServer_Serve(t *testing.T) {
+	hook := &webhookRecorder{}
+	downstream := httptest.NewServer(hook)
+	defer downstream.Close()
+
+	s := newCallbackServer(NewMemoryStore(), Webhook{Url: downstream.URL})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var done = make(chan error, 1)
+	go func() {
+		done <- s.Serve(ctx, l)
+	}()
+
+	base := "http://" + l.Addr().String()
+	for _, path := range []string{HealthPath, ReadyPath} {
+		resp, err := http.Get(base + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: %d", path, resp.StatusCode)
+		}
+	}
+
+	body, _ := json.Marshal(&SubscribeRes{OrderId: 1, Number: "JD0076810087472", Status: StatusDelivered})
+	resp, err := http.Post(base+"/push", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if s.Ready() {
+		t.Error("ready after shutdown")
+	}
+	//停止前等待webhook发送完成
+	if bodies, _ := hook.received(); len(bodies) != 1 {
+		t.Errorf("webhook bodies %d", len(bodies))
+	}
+}
+
+// gateClock After 在测试关闭gate前阻塞
+type gateClock struct {
+	fakeClock
+	gate chan time.Time
+}
+
+func (c *gateClock) After(d time.Duration) <-chan time.Time {
+	return c.gate
+}
+
+// TestCallbackServer_Drain 停止时先使 ReadyPath 返回503，等待 DrainDelay 期间仍接收推送
+func TestCallbackServer_Drain(t *testing.T) {
+	clock := &gateClock{gate: make(chan time.Time)}
+	s := NewCallbackServer(&CallbackServer{
+		Routes: map[string]CallbackProvider{"/push": jsonProvider{}},
+		Store:  NewMemoryStore(),
+		Clock:  clock,
+		Logger: logger.NewZap("callbackServer", "error"),
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var done = make(chan error, 1)
+	go func() {
+		done <- s.Serve(ctx, l)
+	}()
+	base := "http://" + l.Addr().String()
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	cancel()
+
+	for !func() bool {
+		resp, err := client.Get(base + ReadyPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusServiceUnavailable
+	}() {
+		time.Sleep(time.Millisecond)
+	}
+	body, _ := json.Marshal(&SubscribeRes{OrderId: 1, Number: "JD0076810087472", Status: StatusDelivered})
+	resp, err := client.Post(base+"/push", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("push during drain: %d", resp.StatusCode)
+	}
+
+	close(clock.gate)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCallbackServer_Redeliver 发送失败的webhook按 RedeliverInterval 加倍等待后由 redeliverLoop 重新发送
+func TestCallbackServer_Redeliver(t *testing.T) {
+	hook := &webhookRecorder{failures: 2}
+	downstream := httptest.NewServer(hook)
+	defer downstream.Close()
+
+	clock := &gateClock{fakeClock: fakeClock{now: time.Now()}, gate: make(chan time.Time)}
+	s := NewCallbackServer(&CallbackServer{
+		Routes:   map[string]CallbackProvider{"/push": jsonProvider{}},
+		Store:    NewMemoryStore(),
+		Webhooks: []Webhook{{Url: downstream.URL}},
+		Retries:  -1,
+		Clock:    clock,
+		Logger:   logger.NewZap("callbackServer", "error"),
+	})
+	defer s.Shutdown(context.Background())
+	if err := s.Resume(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if code := push(t, s.Handler(), &SubscribeRes{OrderId: 33333, Number: "JD0076810060555", Status: StatusInTransit}); code != http.StatusOK {
+		t.Fatalf("push: %d", code)
+	}
+	calls := func(want int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			bodies, n := hook.received()
+			if n == want {
+				if want < 3 && len(bodies) != 0 || want == 3 && len(bodies) != 1 {
+					t.Fatalf("webhook bodies %d after %d calls", len(bodies), n)
+				}
+				return
+			}
+			if n > want || time.Now().After(deadline) {
+				t.Fatalf("webhook calls %d, want %d", n, want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	//gate每次放行一轮扫描，连续放行两次保证上一轮已结束
+	tick := func() {
+		clock.gate <- time.Time{}
+		clock.gate <- time.Time{}
+	}
+	calls(1)
+	tick()
+	s.wg.Wait()
+	calls(1)
+
+	clock.Advance(DefaultRedeliverInterval)
+	tick()
+	calls(2)
+
+	//第二次失败后等待加倍
+	clock.Advance(DefaultRedeliverInterval)
+	tick()
+	s.wg.Wait()
+	calls(2)
+	clock.Advance(DefaultRedeliverInterval)
+	tick()
+	calls(3)
+	if list, _ := s.Outbox.List(context.Background()); len(list) != 0 {
+		t.Errorf("outbox not empty: %d", len(list))
+	}
+}
+
+// TestCallbackServer_Stopped Shutdown 后到达的推送仍写入 Outbox，但不再发送webhook
+func TestCallbackServer_Stopped(t *testing.T) {
+	hook := &webhookRecorder{}
+	downstream := httptest.NewServer(hook)
+	defer downstream.Close()
+
+	s := newCallbackServer(NewMemoryStore(), Webhook{Url: downstream.URL})
+	handler := s.Handler()
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 1; i <= 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if code := push(t, handler, &SubscribeRes{OrderId: int64(i), Number: fmt.Sprintf("JD%d", i), Status: StatusInTransit}); code != http.StatusOK {
+				t.Errorf("push: %d", code)
+			}
+		}(i)
+	}
+	wg.Wait()
+	s.wg.Wait()
+	if _, calls := hook.received(); calls != 0 {
+		t.Errorf("webhook calls %d after shutdown", calls)
+	}
+	if list, _ := s.Outbox.List(context.Background()); len(list) != 10 {
+		t.Errorf("outbox entries %d", len(list))
+	}
+}
+
+// failingStore Load 返回错误的 Store
+type failingStore struct {
+	Store
+}
+
+func (failingStore) Load(ctx context.Context, orderId int64) (*SubscribeRes, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestCallbackServer_ReadyStore(t *testing.T) {
+	s := newCallbackServer(failingStore{NewMemoryStore()})
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, ReadyPath, nil))
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "connection refused") {
+		t.Errorf("ready with failing store: %d %s", w.Code, w.Body)
+	}
+}
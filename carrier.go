@@ -0,0 +1,144 @@
+package expressTrace
+
+import "strings"
+
+const (
+	ProviderFuqing    = "fuqing"
+	ProviderKuaidi100 = "kuaidi100"
+)
+
+// Carrier 快递公司，Code为统一编码(与快递100编码一致)，Codes为各服务商使用的编码
+type Carrier struct {
+	Code   string            `json:"code"`
+	Name   string            `json:"name"`
+	NameEn string            `json:"nameEn"`
+	Site   string            `json:"site"`
+	Phone  string            `json:"phone"`
+	Logo   string            `json:"logo"`
+	Codes  map[string]string `json:"codes"`
+}
+
+func (c *Carrier) LocalName(lang string) string {
+	if strings.HasPrefix(strings.ToLower(lang), "en") && c.NameEn != "" {
+		return c.NameEn
+	}
+	return c.Name
+}
+
+var carriers = []*Carrier{
+	{Code: "shunfeng", Name: "顺丰速运", NameEn: "SF Express", Site: "www.sf-express.com", Phone: "95338", Codes: map[string]string{ProviderFuqing: "SFEXPRESS", ProviderKuaidi100: "shunfeng"}},
+	{Code: "jd", Name: "京东物流", NameEn: "JD Logistics", Site: "www.jdl.com", Phone: "950616", Codes: map[string]string{ProviderFuqing: "JD", ProviderKuaidi100: "jd"}},
+	{Code: "yuantong", Name: "圆通速递", NameEn: "YTO Express", Site: "www.yto.net.cn", Phone: "95554", Codes: map[string]string{ProviderFuqing: "YTO", ProviderKuaidi100: "yuantong"}},
+	{Code: "zhongtong", Name: "中通快递", NameEn: "ZTO Express", Site: "www.zto.com", Phone: "95311", Codes: map[string]string{ProviderFuqing: "ZTO", ProviderKuaidi100: "zhongtong"}},
+	{Code: "shentong", Name: "申通快递", NameEn: "STO Express", Site: "www.sto.cn", Phone: "95543", Codes: map[string]string{ProviderFuqing: "STO", ProviderKuaidi100: "shentong"}},
+	{Code: "yunda", Name: "韵达快递", NameEn: "Yunda Express", Site: "www.yundaex.com", Phone: "95546", Codes: map[string]string{ProviderFuqing: "YUNDA", ProviderKuaidi100: "yunda"}},
+	{Code: "ems", Name: "EMS", NameEn: "China EMS", Site: "www.ems.com.cn", Phone: "11183", Codes: map[string]string{ProviderFuqing: "EMS", ProviderKuaidi100: "ems"}},
+	{Code: "youzhengguonei", Name: "邮政快递包裹", NameEn: "China Post", Site: "www.chinapost.com.cn", Phone: "11183", Codes: map[string]string{ProviderFuqing: "CHINAPOST", ProviderKuaidi100: "youzhengguonei"}},
+	{Code: "jtexpress", Name: "极兔速递", NameEn: "J&T Express", Site: "www.jtexpress.com.cn", Phone: "956025", Codes: map[string]string{ProviderFuqing: "JITU", ProviderKuaidi100: "jtexpress"}},
+	{Code: "debangkuaidi", Name: "德邦快递", NameEn: "Deppon Express", Site: "www.deppon.com", Phone: "95353", Codes: map[string]string{ProviderFuqing: "DEPPON", ProviderKuaidi100: "debangkuaidi"}},
+	{Code: "huitongkuaidi", Name: "百世快递", NameEn: "Best Express", Site: "www.800best.com", Phone: "95320", Codes: map[string]string{ProviderFuqing: "HTKY", ProviderKuaidi100: "huitongkuaidi"}},
+	{Code: "dhl", Name: "DHL", NameEn: "DHL Express", Site: "www.dhl.com", Phone: "95380", Codes: map[string]string{ProviderFuqing: "DHL", ProviderKuaidi100: "dhl"}},
+	{Code: "ups", Name: "UPS", NameEn: "UPS", Site: "www.ups.com", Phone: "4008208388", Codes: map[string]string{ProviderFuqing: "UPS", ProviderKuaidi100: "ups"}},
+	{Code: "fedex", Name: "联邦快递", NameEn: "FedEx", Site: "www.fedex.com", Phone: "4008891888", Codes: map[string]string{ProviderFuqing: "FEDEX", ProviderKuaidi100: "fedex"}},
+}
+
+// 未单独设置Logo时使用快递100的公司图标
+const logoUrl = "https://cdn.kuaidi100.com/images/all/56/"
+
+var (
+	carrierByCode         = make(map[string]*Carrier)
+	carrierByProviderCode = make(map[string]map[string]*Carrier)
+)
+
+func init() {
+	for _, c := range carriers {
+		if c.Logo == "" {
+			c.Logo = logoUrl + c.Code + ".png"
+		}
+		carrierByCode[c.Code] = c
+		for provider, code := range c.Codes {
+			if carrierByProviderCode[provider] == nil {
+				carrierByProviderCode[provider] = make(map[string]*Carrier)
+			}
+			carrierByProviderCode[provider][strings.ToLower(code)] = c
+		}
+	}
+}
+
+func Carriers() []*Carrier {
+	return append([]*Carrier(nil), carriers...)
+}
+
+// LookupCarrier 按统一编码查找快递公司
+func LookupCarrier(code string) *Carrier {
+	return carrierByCode[strings.ToLower(code)]
+}
+
+// LookupProviderCarrier 按服务商编码查找快递公司
+func LookupProviderCarrier(provider string, code string) *Carrier {
+	return carrierByProviderCode[provider][strings.ToLower(code)]
+}
+
+// CanonicalCompany 将统一编码或任一服务商编码(如 SFEXPRESS)转换为统一编码，company为空时按单号识别，未收录时返回小写编码
+func CanonicalCompany(number string, company string) string {
+	if company == "" {
+		return GuessCompany(number)
+	}
+	if c := LookupCarrier(company); c != nil {
+		return c.Code
+	}
+	for provider := range carrierByProviderCode {
+		if c := LookupProviderCarrier(provider, company); c != nil {
+			return c.Code
+		}
+	}
+	return strings.ToLower(company)
+}
+
+// ProviderCompany 将统一编码或任一服务商编码(如 SFEXPRESS)转换为provider的编码，未收录时原样返回
+func ProviderCompany(provider string, code string) string {
+	if code == "" {
+		return ""
+	}
+	if c := LookupProviderCarrier(provider, code); c != nil {
+		return c.Codes[provider]
+	}
+	if c := LookupCarrier(CanonicalCompany("", code)); c != nil && c.Codes[provider] != "" {
+		return c.Codes[provider]
+	}
+	return code
+}
+
+// FillCompany 按服务商编码填充结果中的快递公司信息，统一为 Carrier 编码。
+// 目录未收录时各服务商填充相同的字段：编码转为小写，名称与图标取服务商返回值(快递100使用其图标地址)，
+// 官网与电话只有部分服务商返回，统一置空
+func FillCompany(res *SubscribeRes, provider string, code string) {
+	res.Provider = provider
+	c := LookupProviderCarrier(provider, code)
+	if c == nil {
+		c = LookupCarrier(code)
+	}
+	if c == nil {
+		res.CompanyCode = strings.ToLower(code)
+		if res.CompanyName == "" {
+			res.CompanyName = res.CompanyCode
+		}
+		if res.CompanyLogo == "" && provider == ProviderKuaidi100 && res.CompanyCode != "" {
+			res.CompanyLogo = logoUrl + res.CompanyCode + ".png"
+		}
+		res.CompanySite = ""
+		res.CompanyPhone = ""
+		return
+	}
+	res.CompanyCode = c.Code
+	res.CompanyName = c.Name
+	if c.Site != "" {
+		res.CompanySite = c.Site
+	}
+	if c.Phone != "" {
+		res.CompanyPhone = c.Phone
+	}
+	if c.Logo != "" {
+		res.CompanyLogo = c.Logo
+	}
+}
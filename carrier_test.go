@@ -0,0 +1,25 @@
+package expressTrace
+
+import "testing"
+
+func TestProviderCompany(t *testing.T) {
+	var cases = []struct {
+		provider string
+		code     string
+		want     string
+	}{
+		{ProviderKuaidi100, "SFEXPRESS", "shunfeng"},
+		{ProviderKuaidi100, "shunfeng", "shunfeng"},
+		{ProviderFuqing, "shunfeng", "SFEXPRESS"},
+		{ProviderFuqing, "sfexpress", "SFEXPRESS"},
+		{ProviderFuqing, "HTKY", "HTKY"},
+		{ProviderKuaidi100, "HTKY", "huitongkuaidi"},
+		{ProviderKuaidi100, "zhaijisong", "zhaijisong"},
+		{ProviderFuqing, "", ""},
+	}
+	for _, c := range cases {
+		if got := ProviderCompany(c.provider, c.code); got != c.want {
+			t.Errorf("ProviderCompany(%s, %s) = %s, want %s", c.provider, c.code, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+// express-trace 使用与 NewWithConfig 相同的配置查询、订阅运单，识别快递公司以及验证推送请求
+//
+//	express-trace [-c development] [-provider fuqing|kuaidi100] [-json] <command> [args]
+//
+//	query <number> [company]              实时查询
+//	subscribe <orderId> <number> [company] 订阅推送
+//	companies                             快递公司列表
+//	detect <number>                       按单号识别快递公司
+//	verify-callback -url <推送地址> [-sign <sign>] [file]
+//	                                      验证抓取的推送请求体(表单或JSON)，file为空时从标准输入读取
+//	serve                                 托管已配置服务商的推送地址，见 serve.go
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/go-tron/config"
+	expressTrace "github.com/go-tron/express-trace"
+	"github.com/go-tron/express-trace/fuqing"
+	"github.com/go-tron/express-trace/kuaidi100"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var (
+	providerFlag = flag.String("provider", expressTrace.ProviderFuqing, "服务商: fuqing, kuaidi100")
+	jsonFlag     = flag.Bool("json", false, "以JSON格式输出")
+)
+
+var ErrorUsage = errors.New("参数错误")
+
+// 子命令及是否需要读取配置
+var commands = map[string]bool{
+	"query":           true,
+	"subscribe":       true,
+	"companies":       true,
+	"detect":          false,
+	"verify-callback": true,
+	"serve":           true,
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	//config.New 自行解析命令行，-c 不在最前面时无法识别，通过环境变量传递
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "c" || f.Name == "config" {
+			os.Setenv("GO_OPT_CONFIG", f.Value.String())
+		}
+	})
+
+	c := &cli{
+		out:      os.Stdout,
+		in:       os.Stdin,
+		json:     *jsonFlag,
+		provider: *providerFlag,
+	}
+	if commands[flag.Arg(0)] {
+		c.config = config.New()
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := c.run(ctx, flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if err == ErrorUsage {
+			usage()
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(flag.CommandLine.Output(), `usage: express-trace [-c env] [-provider fuqing|kuaidi100] [-json] <command> [args]
+
+commands:
+  query <number> [company]
+  subscribe <orderId> <number> [company]
+  companies
+  detect <number>
+  verify-callback -url <callbackUrl> [-sign <sign>] [file]
+  serve`)
+	flag.PrintDefaults()
+}
+
+type cli struct {
+	out       io.Writer
+	in        io.Reader
+	json      bool
+	provider  string
+	config    *config.Config
+	providers map[string]expressTrace.ExpressTrace //已创建的服务商
+}
+
+func (c *cli) run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return ErrorUsage
+	}
+	command, args := args[0], args[1:]
+	switch command {
+	case "query":
+		return c.query(ctx, args)
+	case "subscribe":
+		return c.subscribe(ctx, args)
+	case "companies":
+		return c.companies(ctx)
+	case "detect":
+		return c.detect(args)
+	case "verify-callback":
+		return c.verifyCallback(ctx, args)
+	case "serve":
+		return c.serve(ctx, args)
+	}
+	return ErrorUsage
+}
+
+// expressTrace 返回 -provider 指定的服务商
+func (c *cli) expressTrace() (expressTrace.ExpressTrace, error) {
+	return c.providerByName(c.provider)
+}
+
+// providerByName 使用 NewWithConfig 创建服务商，配置缺失时返回错误而不是panic
+func (c *cli) providerByName(name string) (p expressTrace.ExpressTrace, err error) {
+	if p, ok := c.providers[name]; ok {
+		return p, nil
+	}
+	if c.config == nil {
+		return nil, errors.New("config 必须设置")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s 配置错误: %v", name, r)
+		}
+	}()
+	switch name {
+	case expressTrace.ProviderFuqing:
+		p = fuqing.NewWithConfig(c.config)
+	case expressTrace.ProviderKuaidi100:
+		p = kuaidi100.NewWithConfig(c.config)
+	default:
+		return nil, fmt.Errorf("未知的服务商: %s", name)
+	}
+	if c.providers == nil {
+		c.providers = make(map[string]expressTrace.ExpressTrace)
+	}
+	c.providers[name] = p
+	return p, nil
+}
+
+func (c *cli) query(ctx context.Context, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return ErrorUsage
+	}
+	p, err := c.expressTrace()
+	if err != nil {
+		return err
+	}
+	req := &expressTrace.QueryReq{Number: args[0]}
+	if len(args) > 1 {
+		req.Company = args[1]
+	}
+	res, err := p.Query(ctx, req)
+	if err != nil {
+		return err
+	}
+	return c.print(res)
+}
+
+func (c *cli) subscribe(ctx context.Context, args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return ErrorUsage
+	}
+	orderId, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("orderId 格式错误: %s", args[0])
+	}
+	p, err := c.expressTrace()
+	if err != nil {
+		return err
+	}
+	req := &expressTrace.SubscribeReq{OrderId: orderId, Number: args[1]}
+	if len(args) > 2 {
+		req.Company = args[2]
+	}
+	if err := p.Subscribe(ctx, req); err != nil {
+		return err
+	}
+	return c.print(req)
+}
+
+// companies 福清返回接口中支持推送的快递公司，其他服务商返回内置的快递公司目录
+func (c *cli) companies(ctx context.Context) error {
+	if c.provider == expressTrace.ProviderFuqing {
+		p, err := c.expressTrace()
+		if err != nil {
+			return err
+		}
+		if f, ok := p.(*fuqing.Fuqing); ok {
+			list, err := f.RefreshCompany(ctx)
+			if err != nil {
+				return err
+			}
+			return c.print(list)
+		}
+	}
+	return c.print(expressTrace.Carriers())
+}
+
+func (c *cli) detect(args []string) error {
+	if len(args) != 1 {
+		return ErrorUsage
+	}
+	return c.print(expressTrace.DetectCompany(args[0]))
+}
+
+func (c *cli) verifyCallback(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("verify-callback", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	target := fs.String("url", "", "推送地址，包含orderId等查询参数")
+	sign := fs.String("sign", "", "快递100推送的sign，请求体为JSON时使用")
+	if err := fs.Parse(args); err != nil || *target == "" || fs.NArg() > 1 {
+		return ErrorUsage
+	}
+
+	in := c.in
+	if fs.NArg() == 1 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	body, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	p, err := c.expressTrace()
+	if err != nil {
+		return err
+	}
+	form, err := callbackForm(c.provider, string(body), *sign)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, *target, strings.NewReader(form))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	orderId, data, err := expressTrace.ParseCallback(r)
+	if err != nil {
+		return err
+	}
+	res, err := p.SubscribeCallback(ctx, orderId, data)
+	if err != nil {
+		return err
+	}
+	return c.print(res)
+}
+
+// callbackForm 请求体为JSON时(如从curl的 --data-urlencode 中复制)按服务商字段组装为表单
+func callbackForm(provider string, body string, sign string) (string, error) {
+	body = strings.TrimSpace(body)
+	if !strings.HasPrefix(body, "{") {
+		return body, nil
+	}
+	switch provider {
+	case expressTrace.ProviderFuqing:
+		return url.Values{"data": {body}}.Encode(), nil
+	case expressTrace.ProviderKuaidi100:
+		return url.Values{"param": {body}, "sign": {sign}}.Encode(), nil
+	}
+	return "", fmt.Errorf("未知的服务商: %s", provider)
+}
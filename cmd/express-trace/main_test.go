@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/go-tron/config"
+	expressTrace "github.com/go-tron/express-trace"
+	"github.com/go-tron/express-trace/expressTracetest"
+	"github.com/go-tron/express-trace/fuqing"
+	"github.com/go-tron/logger"
+	"github.com/spf13/viper"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newCli 返回连接福清模拟服务器的命令行
+func newCli(t *testing.T) (*cli, *bytes.Buffer, *expressTracetest.FuqingServer) {
+	server := expressTracetest.NewFuqingServer("test-app-code")
+	t.Cleanup(server.Close)
+	out := &bytes.Buffer{}
+	return &cli{
+		out:      out,
+		provider: expressTrace.ProviderFuqing,
+		providers: map[string]expressTrace.ExpressTrace{
+			expressTrace.ProviderFuqing: fuqing.New(&fuqing.Fuqing{
+				AppKey:       "test-app-key",
+				AppSecret:    "test-app-secret",
+				AppCode:      "test-app-code",
+				SubscribeUrl: "http://192.168.100.100:7031/fuqing",
+				TokenSecret:  "123",
+				QueryBaseUrl: server.URL,
+				PushBaseUrl:  server.URL,
+				Logger:       logger.NewZap("fuqing", "info"),
+			}),
+		},
+	}, out, server
+}
+
+func TestCli_Query(t *testing.T) {
+	c, out, server := newCli(t)
+	server.SetShipment(&expressTracetest.Shipment{
+		Number:  "JD0076810087472",
+		Company: "jd",
+		Status:  expressTrace.StatusDelivered,
+		Traces: []expressTracetest.Trace{
+			{Time: "2022-06-30 10:34:52", Info: "您的快件已由快递驿站代收，感谢您使用京东物流，期待再次为您服务"},
+			{Time: "2022-06-29 15:38:09", Info: "您的快件已到达【西安灞桥分拣中心】"},
+		},
+	})
+	if err := c.run(context.Background(), []string{"query", "JD0076810087472"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"JD0076810087472", "delivered", "2022-06-30 10:34:52", "西安灞桥分拣中心"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("missing %q in\n%s", s, out)
+		}
+	}
+
+	out.Reset()
+	c.json = true
+	if err := c.run(context.Background(), []string{"query", "JD0076810087472", "jd"}); err != nil {
+		t.Fatal(err)
+	}
+	var res expressTrace.SubscribeRes
+	if err := json.Unmarshal(out.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Number != "JD0076810087472" || res.Signed != 1 || len(res.Traces) != 2 {
+		t.Errorf("unexpected result %+v", res)
+	}
+}
+
+func TestCli_Subscribe(t *testing.T) {
+	c, out, server := newCli(t)
+	if err := c.run(context.Background(), []string{"subscribe", "33334", "JD0076810087472"}); err != nil {
+		t.Fatal(err)
+	}
+	callbackUrl, ok := server.Subscribed("JD0076810087472")
+	if !ok || !strings.Contains(callbackUrl, "orderId=33334") {
+		t.Errorf("unexpected callback url %q", callbackUrl)
+	}
+	if !strings.Contains(out.String(), "订阅成功") {
+		t.Errorf("unexpected output\n%s", out)
+	}
+
+	if err := c.run(context.Background(), []string{"subscribe", "x", "JD0076810087472"}); err == nil {
+		t.Error("expected orderId error")
+	}
+}
+
+func TestCli_Detect(t *testing.T) {
+	c := &cli{out: &bytes.Buffer{}}
+	if err := c.run(context.Background(), []string{"detect", "SF1234567890123"}); err != nil {
+		t.Fatal(err)
+	}
+	if out := c.out.(*bytes.Buffer).String(); !strings.Contains(out, "shunfeng") || !strings.Contains(out, "顺丰速运") {
+		t.Errorf("unexpected output\n%s", out)
+	}
+	if err := c.run(context.Background(), []string{"detect"}); err != ErrorUsage {
+		t.Errorf("expected usage error, got %v", err)
+	}
+}
+
+func TestCli_VerifyCallback(t *testing.T) {
+	const target = "http://192.168.100.100:7031/fuqing?orderId=33334&token=5ea915ad234db9589f1683a8113b2bc7a7737827f2e6a76c609460a246d22ee5"
+	const data = `{"code":"OK","no":"JD0076810087472","type":"JD","list":[{"content":"您的快件已由快递驿站代收，感谢您使用京东物流，期待再次为您服务","time":"2022-06-30 10:34:52"},{"content":"您的快件已到达【西安灞桥分拣中心】","time":"2022-06-29 15:38:09"}],"state":"3","name":"京东物流","updateTime":"2022-06-30 10:34:52"}`
+
+	for name, body := range map[string]string{
+		"json": data,
+		"form": url.Values{"data": {data}}.Encode(),
+	} {
+		c, out, _ := newCli(t)
+		c.in = strings.NewReader(body)
+		c.json = true
+		if err := c.run(context.Background(), []string{"verify-callback", "-url", target}); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var res expressTrace.SubscribeRes
+		if err := json.Unmarshal(out.Bytes(), &res); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if res.OrderId != 33334 || res.Status != expressTrace.StatusDelivered {
+			t.Errorf("%s: unexpected result %+v", name, res)
+		}
+	}
+
+	c, _, _ := newCli(t)
+	c.in = strings.NewReader(data)
+	err := c.run(context.Background(), []string{"verify-callback", "-url", strings.Replace(target, "5ea915ad", "00000000", 1)})
+	if err != fuqing.ErrorSign {
+		t.Errorf("expected sign error, got %v", err)
+	}
+}
+
+func TestCli_Serve(t *testing.T) {
+	var received = make(chan []byte, 1)
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- body
+	}))
+	defer downstream.Close()
+
+	dir := t.TempDir()
+	v := viper.New()
+	v.Set("logging.path", dir)
+	v.Set("server.storePath", filepath.Join(dir, "shipments.json"))
+	v.Set("server.outboxPath", filepath.Join(dir, "outbox.json"))
+	v.Set("server.webhooks", []map[string]string{{"url": downstream.URL}})
+	c, _, _ := newCli(t)
+	c.config = &config.Config{Viper: v}
+
+	s, err := c.callbackServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Routes["/fuqing"]; !ok || len(s.Routes) != 1 {
+		t.Fatalf("unexpected routes %v", s.Routes)
+	}
+	if _, ok := s.Outbox.(*expressTrace.FileOutbox); !ok {
+		t.Fatalf("unexpected outbox %T", s.Outbox)
+	}
+
+	const data = `{"code":"OK","no":"JD0076810087472","type":"JD","list":[{"content":"您的快件已到达【西安灞桥分拣中心】","time":"2022-06-29 15:38:09"}],"state":"2"}`
+	r := httptest.NewRequest(http.MethodPost, "/fuqing?orderId=33334&token=5ea915ad234db9589f1683a8113b2bc7a7737827f2e6a76c609460a246d22ee5", strings.NewReader(url.Values{"data": {data}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != fuqing.CallbackSuccess {
+		t.Fatalf("callback: %d %s", w.Code, w.Body)
+	}
+
+	var res expressTrace.SubscribeRes
+	if err := json.Unmarshal(<-received, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.OrderId != 33334 || res.Status != expressTrace.StatusInTransit {
+		t.Errorf("unexpected webhook body %+v", res)
+	}
+	saved, _ := s.Store.Load(context.Background(), 33334)
+	if saved == nil || len(saved.Traces) != 1 {
+		t.Errorf("not saved: %+v", saved)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	expressTrace "github.com/go-tron/express-trace"
+	"github.com/go-tron/express-trace/fuqing"
+	localTime "github.com/go-tron/local-time"
+	"strconv"
+	"text/tabwriter"
+)
+
+// print -json 时输出缩进的JSON，否则按类型输出表格
+func (c *cli) print(v interface{}) error {
+	if c.json {
+		enc := json.NewEncoder(c.out)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	switch v := v.(type) {
+	case *expressTrace.SubscribeRes:
+		printResult(w, v)
+	case *expressTrace.SubscribeReq:
+		fmt.Fprintln(w, "ORDER ID\tNUMBER\tCOMPANY\tRESULT")
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", v.OrderId, v.Number, v.Company, "订阅成功")
+	case []fuqing.Company:
+		fmt.Fprintln(w, "TYPE\tNAME\tPUSH")
+		for _, company := range v {
+			fmt.Fprintf(w, "%s\t%s\t%t\n", company.Type, company.Name, company.Push)
+		}
+	case []*expressTrace.Carrier:
+		fmt.Fprintln(w, "CODE\tNAME\tFUQING\tKUAIDI100\tPHONE")
+		for _, carrier := range v {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", carrier.Code, carrier.Name,
+				carrier.Codes[expressTrace.ProviderFuqing], carrier.Codes[expressTrace.ProviderKuaidi100], carrier.Phone)
+		}
+	case []expressTrace.Candidate:
+		if len(v) == 0 {
+			fmt.Fprintln(w, "未识别到快递公司")
+			break
+		}
+		fmt.Fprintln(w, "COMPANY\tNAME\tCONFIDENCE")
+		for _, candidate := range v {
+			name := ""
+			if carrier := expressTrace.LookupCarrier(candidate.Company); carrier != nil {
+				name = carrier.Name
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", candidate.Company, name, strconv.FormatFloat(candidate.Confidence, 'f', 2, 64))
+		}
+	default:
+		return fmt.Errorf("不支持的输出类型: %T", v)
+	}
+	return w.Flush()
+}
+
+// printResult 输出运单概要与轨迹列表，轨迹按时间倒序
+func printResult(w *tabwriter.Writer, res *expressTrace.SubscribeRes) {
+	fmt.Fprintf(w, "ORDER ID\t%d\n", res.OrderId)
+	fmt.Fprintf(w, "NUMBER\t%s\n", res.Number)
+	fmt.Fprintf(w, "COMPANY\t%s %s\n", res.CompanyCode, res.CompanyName)
+	fmt.Fprintf(w, "PROVIDER\t%s\n", res.Provider)
+	fmt.Fprintf(w, "STATUS\t%s (%s)\n", res.Status, res.State)
+	fmt.Fprintf(w, "SIGNED\t%d\n", res.Signed)
+	if res.Subscription != "" {
+		fmt.Fprintf(w, "SUBSCRIPTION\t%s %s\n", res.Subscription, res.SubscriptionMessage)
+	}
+	if res.LastTraceTime != nil {
+		fmt.Fprintf(w, "LAST TRACE\t%s %s\n", res.LastTraceTime.Format(localTime.Layout), res.LastTraceInfo)
+	}
+	if len(res.Traces) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "TIME\tSTATUS\tINFO")
+	for _, t := range res.Traces {
+		var at string
+		if t.Time != nil {
+			at = t.Time.Format(localTime.Layout)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", at, t.Status, t.Info)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	expressTrace "github.com/go-tron/express-trace"
+	"github.com/go-tron/express-trace/fuqing"
+	"github.com/go-tron/express-trace/kuaidi100"
+	"github.com/go-tron/logger"
+	"net/http"
+	"net/url"
+)
+
+// serve 托管已配置服务商(fuqing.appKey 或 kuaidi100.customer 不为空)的推送地址，路径取自各服务商的 subscribeUrl，
+// 推送合并到 server.storePath 后转发到 server.webhooks，收到 SIGINT/SIGTERM 时优雅停止
+//
+//	server:
+//	  addr: ":8080"
+//	  storePath: ./data/shipments.json #为空时保存在内存中
+//	  outboxPath: ./data/outbox.json #待发送的webhook，为空时保存在内存中，重启后丢失
+//	  webhookTimeout: 10s
+//	  retries: 3
+//	  retryWait: 1s
+//	  shutdownTimeout: 10s
+//	  drainDelay: 5s #停止前 /readyz 返回503的时间，负数时不等待
+//	  redeliverInterval: 1m #重新发送失败webhook的间隔，每次失败后加倍，最长1h，负数时只在重启时重新发送
+//	  webhooks:
+//	    - url: http://order.internal/express/notify
+//	      secret: xxx
+func (c *cli) serve(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return ErrorUsage
+	}
+	s, err := c.callbackServer()
+	if err != nil {
+		return err
+	}
+	return s.Run(ctx)
+}
+
+// 服务商及判断其是否已配置的配置项
+var serveProviders = []struct {
+	name string
+	key  string
+}{
+	{expressTrace.ProviderFuqing, "fuqing.appKey"},
+	{expressTrace.ProviderKuaidi100, "kuaidi100.customer"},
+}
+
+func (c *cli) callbackServer() (*expressTrace.CallbackServer, error) {
+	var routes = make(map[string]expressTrace.CallbackProvider)
+	for _, v := range serveProviders {
+		if _, ok := c.providers[v.name]; !ok && c.config.GetString(v.key) == "" {
+			continue
+		}
+		p, err := c.providerByName(v.name)
+		if err != nil {
+			return nil, err
+		}
+		var subscribeUrl string
+		switch p := p.(type) {
+		case *fuqing.Fuqing:
+			subscribeUrl = p.SubscribeUrl
+		case *kuaidi100.Kuaidi100:
+			subscribeUrl = p.SubscribeUrl
+		}
+		path := "/" + v.name
+		if u, err := url.Parse(subscribeUrl); err == nil && u.Path != "" && u.Path != "/" {
+			path = u.Path
+		}
+		if _, ok := routes[path]; ok {
+			return nil, fmt.Errorf("推送路径重复: %s", path)
+		}
+		callbackProvider, ok := p.(expressTrace.CallbackProvider)
+		if !ok {
+			return nil, fmt.Errorf("%s 不支持推送", v.name)
+		}
+		routes[path] = callbackProvider
+	}
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("未配置服务商")
+	}
+
+	var store expressTrace.Store = expressTrace.NewMemoryStore()
+	if path := c.config.GetString("server.storePath"); path != "" {
+		fileStore, err := expressTrace.NewFileStore(path)
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	}
+
+	var outbox expressTrace.Outbox
+	if path := c.config.GetString("server.outboxPath"); path != "" {
+		fileOutbox, err := expressTrace.NewFileOutbox(path)
+		if err != nil {
+			return nil, err
+		}
+		outbox = fileOutbox
+	}
+
+	var webhooks []expressTrace.Webhook
+	if err := c.config.UnmarshalKey("server.webhooks", &webhooks); err != nil {
+		return nil, fmt.Errorf("server.webhooks 配置错误: %v", err)
+	}
+
+	var client *http.Client
+	if timeout := c.config.GetDuration("server.webhookTimeout"); timeout != 0 {
+		client = &http.Client{Timeout: timeout}
+	}
+
+	var s *expressTrace.CallbackServer
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("server 配置错误: %v", r)
+			}
+		}()
+		s = expressTrace.NewCallbackServer(&expressTrace.CallbackServer{
+			Addr:              c.config.GetString("server.addr"),
+			Routes:            routes,
+			Store:             store,
+			Webhooks:          webhooks,
+			Outbox:            outbox,
+			Client:            client,
+			Retries:           c.config.GetInt("server.retries"),
+			RetryWait:         c.config.GetDuration("server.retryWait"),
+			ShutdownTimeout:   c.config.GetDuration("server.shutdownTimeout"),
+			DrainDelay:        c.config.GetDuration("server.drainDelay"),
+			RedeliverInterval: c.config.GetDuration("server.redeliverInterval"),
+			Logger:            logger.NewZapWithConfig(c.config, "express-trace", "info", logger.WithConsole()),
+		})
+		return nil
+	}()
+	return s, err
+}
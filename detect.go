@@ -0,0 +1,101 @@
+package expressTrace
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DetectThreshold GuessCompany 采用识别结果的最低置信度
+const DetectThreshold = 0.8
+
+type Candidate struct {
+	Company    string  `json:"company"` //快递公司编码，与快递100编码一致
+	Confidence float64 `json:"confidence"`
+}
+
+type detectRule struct {
+	company    string
+	pattern    *regexp.Regexp
+	confidence float64
+}
+
+// 按单号格式识别，同一格式可能属于多家快递公司，以置信度区分
+var detectRules = []detectRule{
+	{"shunfeng", regexp.MustCompile(`^SF\d{12,13}$`), 0.95},
+	{"jd", regexp.MustCompile(`^JD[A-Z]{0,2}\d{11,15}$`), 0.95},
+	{"yuantong", regexp.MustCompile(`^YT\d{13,15}$`), 0.95},
+	{"ups", regexp.MustCompile(`^1Z[0-9A-Z]{16}$`), 0.98},
+	{"ems", regexp.MustCompile(`^[A-Z]{2}\d{9}CN$`), 0.9},
+	{"dhl", regexp.MustCompile(`^J?JD\d{18}$`), 0.85},
+	{"fedex", regexp.MustCompile(`^(96|61)\d{20}$`), 0.7},
+	{"ems", regexp.MustCompile(`^E[A-Z]\d{9}[A-Z]{2}$`), 0.7},
+	{"shentong", regexp.MustCompile(`^77\d{11}$`), 0.7},
+	{"zhongtong", regexp.MustCompile(`^7[0-9]\d{12}$`), 0.6},
+	{"dhl", regexp.MustCompile(`^\d{10}$`), 0.6},
+	{"shentong", regexp.MustCompile(`^(268|368|468|568|668|868|888|900)\d{9}$`), 0.6},
+	{"zhongtong", regexp.MustCompile(`^(5[0-9]|6[0-9]|7[0-9])\d{10}$`), 0.5},
+	{"yunda", regexp.MustCompile(`^(10|11|12|13|19|31|33|34|35|36|37|38|39|43|46|50|51|52|53|54)\d{11}$`), 0.5},
+	{"fedex", regexp.MustCompile(`^\d{12}$`), 0.5},
+	{"fedex", regexp.MustCompile(`^\d{15}$`), 0.5},
+	{"ups", regexp.MustCompile(`^T\d{10}$`), 0.5},
+	{"ems", regexp.MustCompile(`^[159]\d{12}$`), 0.4},
+	{"shunfeng", regexp.MustCompile(`^\d{12}$`), 0.3},
+}
+
+// NormalizeNumber 去除空白与连字符并转为大写，服务商返回的单号格式可能与提交时不同
+func NormalizeNumber(number string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(number)))
+}
+
+// DetectCompany 离线按单号规则识别可能的快递公司，按置信度从高到低排序
+func DetectCompany(number string) []Candidate {
+	number = NormalizeNumber(number)
+	var candidates = make([]Candidate, 0)
+	var index = make(map[string]int)
+	for _, rule := range detectRules {
+		if !rule.pattern.MatchString(number) {
+			continue
+		}
+		if i, ok := index[rule.company]; ok {
+			if rule.confidence > candidates[i].Confidence {
+				candidates[i].Confidence = rule.confidence
+			}
+			continue
+		}
+		index[rule.company] = len(candidates)
+		candidates = append(candidates, Candidate{
+			Company:    rule.company,
+			Confidence: rule.confidence,
+		})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Confidence > candidates[j].Confidence
+	})
+	return candidates
+}
+
+// GuessCompany 返回置信度不低于 DetectThreshold 的快递公司，无法确定时返回空
+func GuessCompany(number string) string {
+	candidates := DetectCompany(number)
+	if len(candidates) == 0 || candidates[0].Confidence < DetectThreshold {
+		return ""
+	}
+	return candidates[0].Company
+}
+
+// GuessCompany 未指定快递公司时按单号识别并写入Company(统一编码)，调用方可由此获知识别结果，无法确定时保持为空
+func (r *QueryReq) GuessCompany() string {
+	if r.Company == "" {
+		r.Company = GuessCompany(r.Number)
+	}
+	return r.Company
+}
+
+// GuessCompany 同 QueryReq.GuessCompany
+func (r *SubscribeReq) GuessCompany() string {
+	if r.Company == "" {
+		r.Company = GuessCompany(r.Number)
+	}
+	return r.Company
+}
@@ -0,0 +1,45 @@
+package expressTrace
+
+import "testing"
+
+func TestDetectCompany(t *testing.T) {
+	var cases = []struct {
+		number string
+		want   string
+	}{
+		{"JD0076810060555", "jd"},
+		{"JD0076810087472", "jd"},
+		{"SF1234567890123", "shunfeng"},
+		{"sf 123456789012", "shunfeng"},
+		{"YT1234567890123", "yuantong"},
+		{"1Z999AA10123456784", "ups"},
+		{"EA123456789CN", "ems"},
+		{"JJD000390007788551234", "dhl"},
+		{"7712345678901", "shentong"},
+		{"73123456789012", "zhongtong"},
+		{"1234567890", "dhl"},
+		{"9612345678901234567890", "fedex"},
+	}
+	for _, c := range cases {
+		candidates := DetectCompany(c.number)
+		if len(candidates) == 0 || candidates[0].Company != c.want {
+			t.Errorf("DetectCompany(%q) = %v, want %s", c.number, candidates, c.want)
+		}
+	}
+
+	if candidates := DetectCompany("invalid"); len(candidates) != 0 {
+		t.Errorf("DetectCompany(invalid) = %v", candidates)
+	}
+	if company := GuessCompany("123456789012"); company != "" {
+		t.Errorf("ambiguous number guessed as %s", company)
+	}
+
+	query := &QueryReq{Number: "SF1234567890123"}
+	if company := query.GuessCompany(); company != "shunfeng" || query.Company != "shunfeng" {
+		t.Errorf("QueryReq.GuessCompany = %s, company %s", company, query.Company)
+	}
+	subscribe := &SubscribeReq{Number: "SF1234567890123", Company: "SFEXPRESS"}
+	if subscribe.GuessCompany(); subscribe.Company != "SFEXPRESS" {
+		t.Errorf("specified company overwritten: %s", subscribe.Company)
+	}
+}
@@ -1,6 +1,17 @@
 package expressTrace
 
-import "github.com/go-tron/local-time"
+import (
+	"context"
+	"github.com/go-tron/local-time"
+	"time"
+)
+
+type QueryReq struct {
+	OrderId int64  `json:"orderId,string"`
+	Number  string `json:"number" validate:"required"`
+	Company string `json:"company"`
+	Phone   string `json:"phone"` //收/寄件人手机号，部分快递公司(如顺丰)查询时必填
+}
 
 type SubscribeReq struct {
 	OrderId int64  `json:"orderId,string" validate:"required"`
@@ -12,7 +23,8 @@ type SubscribeRes struct {
 	OrderId       int64           `json:"orderId,string" validate:"required"`
 	Number        string          `json:"number" validate:"required"`
 	Signed        int             `json:"signed"`
-	Status        string          `json:"status"`
+	Status        Status          `json:"status"`
+	State         string          `json:"state"` //服务商原始状态码
 	LastTraceInfo string          `json:"lastTraceInfo"`
 	LastTraceTime *localTime.Time `json:"lastTraceTime"`
 	Traces        []Trace         `json:"traces"`
@@ -21,14 +33,51 @@ type SubscribeRes struct {
 	CompanySite   string          `json:"companySite"`
 	CompanyPhone  string          `json:"companyPhone"`
 	CompanyLogo   string          `json:"companyLogo"`
+	Provider      string          `json:"provider"`
+	Origin        *Location       `json:"origin,omitempty"`       //出发地
+	Current       *Location       `json:"current,omitempty"`      //当前所在地
+	Destination   *Location       `json:"destination,omitempty"`  //目的地
+	Courier       string          `json:"courier,omitempty"`      //快递员
+	CourierPhone  string          `json:"courierPhone,omitempty"` //快递员电话
+	UpdateTime    *localTime.Time `json:"updateTime,omitempty"`   //轨迹最新更新时间
+	TakeTime      time.Duration   `json:"takeTime,omitempty"`     //发货到收货消耗时长
+
+	Subscription        SubscriptionStatus `json:"subscription,omitempty"`        //服务商推送的订阅状态
+	SubscriptionMessage string             `json:"subscriptionMessage,omitempty"` //订阅状态说明，如中止原因
+	BillStatus          string             `json:"billStatus,omitempty"`          //快递100的billstatus
+	Resubscribed        bool               `json:"resubscribed,omitempty"`        //本次订阅中止后已自动重新订阅
+	Resubscribes        int                `json:"resubscribes,omitempty"`        //已自动重新订阅的次数，由 CallbackServer 记录在 Store 中
+	Sequence            int64              `json:"sequence,omitempty"`            //Merge 每次产生变化时递增，下游据此丢弃过期的推送
+	Notified            int64              `json:"-"`                             //已写入 Outbox 的 Sequence，由 CallbackServer 维护，自定义 Store 需保存该字段
+}
+
+type Location struct {
+	AreaCode string `json:"areaCode"`           //行政区划编码，如 CN610111000000
+	AreaName string `json:"areaName"`           //行政区划名称，如 陕西,西安市,灞桥区
+	Facility string `json:"facility,omitempty"` //网点或分拣中心，如 西安兴善营业部
 }
 
 type Trace struct {
-	Time *localTime.Time `json:"time"`
-	Info string          `json:"info"`
+	Time      *localTime.Time `json:"time"`
+	Info      string          `json:"info"`
+	Location  *Location       `json:"location,omitempty"`
+	Status    Status          `json:"status,omitempty"`    //该节点的统一状态
+	State     string          `json:"state,omitempty"`     //服务商原始状态码
+	StateName string          `json:"stateName,omitempty"` //服务商原始状态名称，如 在途、干线
+	Detail    *TraceDetail    `json:"detail,omitempty"`    //从轨迹文本解析出的信息
+}
+
+type TraceDetail struct {
+	Courier      string   `json:"courier,omitempty"`      //快递员
+	CourierPhone string   `json:"courierPhone,omitempty"` //快递员电话
+	Facilities   []string `json:"facilities,omitempty"`   //网点、分拣中心
+	PickupCode   string   `json:"pickupCode,omitempty"`   //取件码
+	Station      string   `json:"station,omitempty"`      //驿站、快递柜
+	StationEvent bool     `json:"stationEvent,omitempty"` //已由驿站、快递柜代收
 }
 
 type ExpressTrace interface {
-	Subscribe(*SubscribeReq) error
-	SubscribeCallback(int64, map[string]string) (*SubscribeRes, error)
+	Query(context.Context, *QueryReq) (*SubscribeRes, error)
+	Subscribe(context.Context, *SubscribeReq) error
+	SubscribeCallback(context.Context, int64, map[string]string) (*SubscribeRes, error)
 }
@@ -0,0 +1,207 @@
+package expressTracetest
+
+import (
+	"context"
+	baseError "github.com/go-tron/base-error"
+	expressTrace "github.com/go-tron/express-trace"
+	"net/http"
+	"testing"
+)
+
+// 服务商统一使用的错误码
+const (
+	CodeParam          = "3011"
+	CodeFail           = "3014"
+	CodeCallbackParams = "3015"
+	CodeSign           = "3016"
+)
+
+// Server 模拟服务器，FuqingServer 与 Kuaidi100Server 均已实现
+type Server interface {
+	SetShipment(shipment *Shipment)
+	SetError(number string, code string)
+	CallbackRequest(ctx context.Context, number string) (*http.Request, error)
+}
+
+var (
+	_ Server = (*FuqingServer)(nil)
+	_ Server = (*Kuaidi100Server)(nil)
+)
+
+// Conformance 一致性测试的被测服务商，Provider 须连接 Server 且推送地址可被 expressTrace.ParseCallback 解析
+type Conformance struct {
+	Provider  expressTrace.ExpressTrace
+	Server    Server
+	SignField string                                 //推送中的签名字段，如福清的token、快递100的sign
+	States    []string                               //服务商全部原始状态码，与Status一同设置时检查状态映射
+	Status    func(state string) expressTrace.Status //原始状态码转换为统一状态
+}
+
+// 一致性测试使用的运单号与快递公司
+const (
+	conformanceNumber  = "JD0076810060555"
+	conformanceCompany = "jd"
+)
+
+// RunConformance 检查 ExpressTrace 实现的参数校验、推送参数与签名校验、空轨迹、状态映射与OrderId传递是否与约定一致
+func RunConformance(t *testing.T, c *Conformance) {
+	t.Helper()
+	ctx := context.Background()
+
+	t.Run("Validation", func(t *testing.T) {
+		_, err := c.Provider.Query(ctx, &expressTrace.QueryReq{OrderId: 1})
+		requireCode(t, err, CodeParam)
+		requireCode(t, c.Provider.Subscribe(ctx, &expressTrace.SubscribeReq{Number: conformanceNumber}), CodeParam)
+		requireCode(t, c.Provider.Subscribe(ctx, &expressTrace.SubscribeReq{OrderId: 1}), CodeParam)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		number := "JD0076810099999"
+		c.Server.SetShipment(&Shipment{Number: number, Company: conformanceCompany, Status: expressTrace.StatusInTransit, Traces: traces()})
+		defer c.Server.SetError(number, "")
+		for _, code := range []string{CodeWrongNumber, CodeNoCompany, CodeNoInfo, CodeLimited} {
+			c.Server.SetError(number, code)
+			_, err := c.Provider.Query(ctx, &expressTrace.QueryReq{OrderId: 1, Number: number})
+			requireCode(t, err, CodeFail)
+			requireCode(t, c.Provider.Subscribe(ctx, &expressTrace.SubscribeReq{OrderId: 1, Number: number}), CodeFail)
+		}
+	})
+
+	t.Run("OrderId", func(t *testing.T) {
+		c.Server.SetShipment(&Shipment{Number: conformanceNumber, Company: conformanceCompany, Status: expressTrace.StatusInTransit, Traces: traces()})
+		res, err := c.Provider.Query(ctx, &expressTrace.QueryReq{OrderId: 10001, Number: conformanceNumber})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.OrderId != 10001 || res.Number != conformanceNumber {
+			t.Errorf("Query: orderId %d, number %s", res.OrderId, res.Number)
+		}
+
+		res, err = c.callback(t, 10002)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.OrderId != 10002 || res.Number != conformanceNumber {
+			t.Errorf("SubscribeCallback: orderId %d, number %s", res.OrderId, res.Number)
+		}
+	})
+
+	t.Run("CallbackParams", func(t *testing.T) {
+		c.Server.SetShipment(&Shipment{Number: conformanceNumber, Company: conformanceCompany, Status: expressTrace.StatusInTransit, Traces: traces()})
+		orderId, data := c.callbackData(t, 10003)
+		//orderId 由推送地址传入，缺失时 ParseCallback 返回0
+		_, err := c.Provider.SubscribeCallback(ctx, 0, data)
+		requireCode(t, err, CodeCallbackParams)
+		for field := range data {
+			if field == "orderId" {
+				continue
+			}
+			var missing = make(map[string]string)
+			for k, v := range data {
+				if k != field {
+					missing[k] = v
+				}
+			}
+			_, err := c.Provider.SubscribeCallback(ctx, orderId, missing)
+			requireCode(t, err, CodeCallbackParams)
+		}
+	})
+
+	t.Run("Sign", func(t *testing.T) {
+		c.Server.SetShipment(&Shipment{Number: conformanceNumber, Company: conformanceCompany, Status: expressTrace.StatusInTransit, Traces: traces()})
+		orderId, data := c.callbackData(t, 10004)
+		data[c.SignField] = "invalid"
+		_, err := c.Provider.SubscribeCallback(ctx, orderId, data)
+		requireCode(t, err, CodeSign)
+	})
+
+	t.Run("EmptyTraces", func(t *testing.T) {
+		c.Server.SetShipment(&Shipment{Number: conformanceNumber, Company: conformanceCompany, Status: expressTrace.StatusPending})
+		res, err := c.callback(t, 10005)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Traces == nil || len(res.Traces) != 0 || res.LastTraceInfo != "" || res.LastTraceTime != nil || res.Signed != 0 {
+			t.Errorf("unexpected result %+v", res)
+		}
+	})
+
+	t.Run("Status", func(t *testing.T) {
+		for _, status := range expressTrace.Statuses() {
+			if status == expressTrace.StatusUnknown || status == expressTrace.StatusInvalid || status == expressTrace.StatusPending {
+				continue
+			}
+			c.Server.SetShipment(&Shipment{Number: conformanceNumber, Company: conformanceCompany, Status: status, Traces: traces()})
+			query, err := c.Provider.Query(ctx, &expressTrace.QueryReq{OrderId: 1, Number: conformanceNumber})
+			if err != nil {
+				t.Fatalf("%s: %v", status, err)
+			}
+			callback, err := c.callback(t, 1)
+			if err != nil {
+				t.Fatalf("%s: %v", status, err)
+			}
+			for _, res := range []*expressTrace.SubscribeRes{query, callback} {
+				if !res.Status.Valid() || res.Status == expressTrace.StatusUnknown {
+					t.Errorf("%s: got %q (state %s)", status, res.Status, res.State)
+				}
+				if (status == expressTrace.StatusDelivered) != (res.Signed == 1) {
+					t.Errorf("%s: signed %d", status, res.Signed)
+				}
+				switch status {
+				case expressTrace.StatusAccepted, expressTrace.StatusInTransit, expressTrace.StatusDelivered:
+					if res.Status != status {
+						t.Errorf("%s: got %s", status, res.Status)
+					}
+				}
+			}
+		}
+
+		if c.Status == nil {
+			return
+		}
+		for _, state := range c.States {
+			if s := c.Status(state); !s.Valid() || s == expressTrace.StatusUnknown {
+				t.Errorf("state %s: got %q", state, s)
+			}
+		}
+	})
+}
+
+// callbackData 订阅运单并返回模拟服务器推送请求经 expressTrace.ParseCallback 解析后的参数
+func (c *Conformance) callbackData(t *testing.T, orderId int64) (int64, map[string]string) {
+	t.Helper()
+	ctx := context.Background()
+	if err := c.Provider.Subscribe(ctx, &expressTrace.SubscribeReq{OrderId: orderId, Number: conformanceNumber}); err != nil {
+		t.Fatal(err)
+	}
+	req, err := c.Server.CallbackRequest(ctx, conformanceNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, data, err := expressTrace.ParseCallback(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id, data
+}
+
+func (c *Conformance) callback(t *testing.T, orderId int64) (*expressTrace.SubscribeRes, error) {
+	t.Helper()
+	id, data := c.callbackData(t, orderId)
+	return c.Provider.SubscribeCallback(context.Background(), id, data)
+}
+
+func traces() []Trace {
+	return []Trace{
+		{Time: "2022-06-30 08:27:50", Info: "您的快件正在派送中，请您准备签收（快递员：薛兵，联系电话：18740476340）"},
+		{Time: "2022-06-29 22:28:45", Info: "您的快件在【西安灞桥分拣中心】分拣完成"},
+	}
+}
+
+func requireCode(t *testing.T, err error, code string) {
+	t.Helper()
+	e, ok := err.(*baseError.Error)
+	if !ok || e.Code != code {
+		t.Errorf("expected error %s, got %v", code, err)
+	}
+}
@@ -0,0 +1,245 @@
+package expressTracetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	expressTrace "github.com/go-tron/express-trace"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+// 统一状态对应的福清推送state
+var fuqingState = map[expressTrace.Status]string{
+	expressTrace.StatusInvalid:    "-1",
+	expressTrace.StatusPending:    "0",
+	expressTrace.StatusAccepted:   "1",
+	expressTrace.StatusInTransit:  "2",
+	expressTrace.StatusDelivering: "2",
+	expressTrace.StatusDelivered:  "3",
+	expressTrace.StatusException:  "4",
+	expressTrace.StatusReturning:  "2",
+	expressTrace.StatusReturned:   "6",
+	expressTrace.StatusRejected:   "4",
+}
+
+// 统一状态对应的福清实时查询deliverystatus
+var fuqingDeliveryStatus = map[expressTrace.Status]string{
+	expressTrace.StatusAccepted:   "0",
+	expressTrace.StatusInTransit:  "1",
+	expressTrace.StatusDelivering: "2",
+	expressTrace.StatusDelivered:  "3",
+	expressTrace.StatusException:  "5",
+	expressTrace.StatusReturning:  "1",
+	expressTrace.StatusReturned:   "6",
+	expressTrace.StatusRejected:   "4",
+}
+
+// FuqingServer 模拟福清实时查询(/kdi)、推送订阅(/expresspush)与快递公司列表(/pushExpressLists)接口，
+// 同时作为 Fuqing.QueryBaseUrl 与 Fuqing.PushBaseUrl 使用
+type FuqingServer struct {
+	*httptest.Server
+	*shipments
+	AppCode string //校验 Authorization: APPCODE，为空时不校验
+}
+
+func NewFuqingServer(appCode string) *FuqingServer {
+	s := &FuqingServer{
+		shipments: newShipments(),
+		AppCode:   appCode,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/kdi", s.query)
+	mux.HandleFunc("/expresspush", s.subscribe)
+	mux.HandleFunc("/pushExpressLists", s.companies)
+	s.Server = httptest.NewServer(s.authorize(mux))
+	return s
+}
+
+// authorize 与阿里云网关一致，APPCODE错误时返回401与空响应体
+func (s *FuqingServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.AppCode != "" && r.Header.Get("Authorization") != "APPCODE "+s.AppCode {
+			w.Header().Set("X-Ca-Error-Message", "Invalid AppCode")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *FuqingServer) query(w http.ResponseWriter, r *http.Request) {
+	number := r.URL.Query().Get("no")
+	shipment, code := s.lookup(number)
+	if code == "" && shipment.Status == expressTrace.StatusInvalid {
+		code = CodeWrongNumber
+	}
+	if code == "" && len(shipment.Traces) == 0 {
+		code = CodeNoInfo
+	}
+	if code != "" {
+		writeJSON(w, map[string]interface{}{
+			"status": code,
+			"msg":    CodeMessage(code),
+			"result": map[string]interface{}{},
+		})
+		return
+	}
+
+	status := fuqingDeliveryStatus[shipment.Status]
+	issign := "0"
+	if shipment.Status == expressTrace.StatusDelivered {
+		issign = "1"
+	}
+	var list = make([]map[string]string, 0, len(shipment.Traces))
+	for _, t := range shipment.Traces {
+		list = append(list, map[string]string{"time": t.Time, "status": t.Info})
+	}
+	result := map[string]interface{}{
+		"number":         shipment.Number,
+		"type":           shipment.company(expressTrace.ProviderFuqing),
+		"deliverystatus": status,
+		"issign":         issign,
+		"list":           list,
+	}
+	if t := shipment.lastTime(); t != "" {
+		result["updateTime"] = t
+	}
+	if c := expressTrace.LookupCarrier(shipment.Company); c != nil {
+		result["expName"] = c.Name
+		result["expSite"] = c.Site
+		result["expPhone"] = c.Phone
+		result["logo"] = c.Logo
+	}
+	writeJSON(w, map[string]interface{}{
+		"status": "0",
+		"msg":    "ok",
+		"result": result,
+	})
+}
+
+func (s *FuqingServer) subscribe(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	number, callbackUrl := q.Get("no"), q.Get("url")
+	code := ""
+	if number == "" || callbackUrl == "" {
+		code = CodeWrongNumber
+	} else {
+		code = s.shipments.subscribe(number, subscription{url: callbackUrl})
+	}
+	if code != "" {
+		writeJSON(w, map[string]interface{}{
+			"status":  false,
+			"code":    code,
+			"no":      number,
+			"message": CodeMessage(code),
+		})
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"status":  true,
+		"code":    "OK",
+		"no":      number,
+		"type":    q.Get("type"),
+		"url":     callbackUrl,
+		"message": "订阅成功",
+	})
+}
+
+// companies 返回 expressTrace.Carriers 中福清支持的快递公司
+func (s *FuqingServer) companies(w http.ResponseWriter, r *http.Request) {
+	var list = make([]map[string]string, 0)
+	for _, c := range expressTrace.Carriers() {
+		if code := c.Codes[expressTrace.ProviderFuqing]; code != "" {
+			list = append(list, map[string]string{"type": code, "name": c.Name, "logo": c.Logo})
+		}
+	}
+	writeJSON(w, map[string]interface{}{
+		"status":  true,
+		"message": "",
+		"result":  list,
+	})
+}
+
+// Callback 按福清推送格式将运单POST到订阅时提交的url(已包含orderId与token)，推送方返回 success 时成功
+func (s *FuqingServer) Callback(ctx context.Context, number string) error {
+	req, err := s.CallbackRequest(ctx, number)
+	if err != nil {
+		return err
+	}
+	status, resp, err := do(req)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK || resp != "success" {
+		return fmt.Errorf("推送失败: %d %s", status, resp)
+	}
+	return nil
+}
+
+// CallbackRequest 返回福清格式的推送请求，表单字段data为运单JSON
+func (s *FuqingServer) CallbackRequest(ctx context.Context, number string) (*http.Request, error) {
+	shipment, sub, ok := s.callback(number)
+	if !ok {
+		return nil, errors.New("运单未订阅:" + number)
+	}
+
+	var list = make([]map[string]string, 0, len(shipment.Traces))
+	for _, t := range shipment.Traces {
+		list = append(list, map[string]string{"time": t.Time, "content": t.Info})
+	}
+	state, ok := fuqingState[shipment.Status]
+	if !ok {
+		state = "2"
+	}
+	data := map[string]interface{}{
+		"code":  "OK",
+		"no":    shipment.Number,
+		"type":  shipment.company(expressTrace.ProviderFuqing),
+		"state": state,
+		"list":  list,
+	}
+	if t := shipment.lastTime(); t != "" {
+		data["updateTime"] = t
+	}
+	if c := expressTrace.LookupCarrier(shipment.Company); c != nil {
+		data["name"] = c.Name
+		data["site"] = c.Site
+		data["phone"] = c.Phone
+		data["logo"] = c.Logo
+	}
+	body, _ := json.Marshal(data)
+	return formRequest(ctx, sub.url, url.Values{"data": {string(body)}})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	body, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(body)
+}
+
+func formRequest(ctx context.Context, target string, form url.Values) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+func do(req *http.Request) (int, string, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, "", err
+	}
+	return resp.StatusCode, string(body), nil
+}
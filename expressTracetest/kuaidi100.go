@@ -0,0 +1,221 @@
+package expressTracetest
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	expressTrace "github.com/go-tron/express-trace"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+// 统一状态对应的快递100 state
+var kuaidi100State = map[expressTrace.Status]string{
+	expressTrace.StatusAccepted:   "1",
+	expressTrace.StatusInTransit:  "0",
+	expressTrace.StatusDelivering: "5",
+	expressTrace.StatusDelivered:  "3",
+	expressTrace.StatusException:  "2",
+	expressTrace.StatusReturning:  "6",
+	expressTrace.StatusReturned:   "4",
+	expressTrace.StatusRejected:   "14",
+}
+
+// Kuaidi100Server 模拟快递100订阅(/poll)与实时查询(/poll/query.do)接口，作为 Kuaidi100.BaseUrl 使用
+type Kuaidi100Server struct {
+	*httptest.Server
+	*shipments
+	Key      string //校验订阅参数中的key与查询签名，为空时不校验
+	Customer string //校验查询的customer，为空时不校验
+}
+
+func NewKuaidi100Server(key string, customer string) *Kuaidi100Server {
+	s := &Kuaidi100Server{
+		shipments: newShipments(),
+		Key:       key,
+		Customer:  customer,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/poll", s.subscribe)
+	mux.HandleFunc("/poll/query.do", s.query)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *Kuaidi100Server) fail(w http.ResponseWriter, code string, message string) {
+	writeJSON(w, map[string]interface{}{
+		"result":     false,
+		"returnCode": code,
+		"message":    message,
+	})
+}
+
+func (s *Kuaidi100Server) subscribe(w http.ResponseWriter, r *http.Request) {
+	var param struct {
+		Company    string `json:"company"`
+		Number     string `json:"number"`
+		Key        string `json:"key"`
+		Parameters struct {
+			Callbackurl string `json:"callbackurl"`
+			Salt        string `json:"salt"`
+		} `json:"parameters"`
+	}
+	if err := json.Unmarshal([]byte(r.FormValue("param")), &param); err != nil {
+		s.fail(w, "700", "订阅方的订阅数据存在错误")
+		return
+	}
+	if s.Key != "" && param.Key != s.Key {
+		s.fail(w, "600", "您不是合法的订阅者")
+		return
+	}
+	if param.Number == "" || param.Parameters.Callbackurl == "" {
+		s.fail(w, "700", "订阅方的订阅数据存在错误")
+		return
+	}
+	if code := s.shipments.subscribe(param.Number, subscription{
+		url:  param.Parameters.Callbackurl,
+		salt: param.Parameters.Salt,
+	}); code != "" {
+		s.fail(w, code, CodeMessage(code))
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"result":     true,
+		"returnCode": "200",
+		"message":    "提交成功",
+	})
+}
+
+func (s *Kuaidi100Server) query(w http.ResponseWriter, r *http.Request) {
+	param := r.FormValue("param")
+	if s.Customer != "" && r.FormValue("customer") != s.Customer {
+		s.fail(w, "601", "POLL:KEY已过期或不存在")
+		return
+	}
+	if s.Key != "" && r.FormValue("sign") != sign(param+s.Key+r.FormValue("customer")) {
+		s.fail(w, "503", "验证签名失败")
+		return
+	}
+	var req struct {
+		Num string `json:"num"`
+	}
+	if err := json.Unmarshal([]byte(param), &req); err != nil || req.Num == "" {
+		s.fail(w, "400", "找不到对应公司")
+		return
+	}
+
+	shipment, code := s.lookup(req.Num)
+	if code == "" && shipment.Status == expressTrace.StatusInvalid {
+		code = CodeWrongNumber
+	}
+	if code == "" && len(shipment.Traces) == 0 {
+		code = "500"
+	}
+	if code != "" {
+		message := CodeMessage(code)
+		if code == "500" || code == CodeNoInfo {
+			message = "查询无结果，请隔段时间再查"
+		}
+		s.fail(w, code, message)
+		return
+	}
+	writeJSON(w, kuaidi100Result(shipment))
+}
+
+// kuaidi100Result 运单转换为快递100 resultv2=4 格式的lastResult
+func kuaidi100Result(shipment *Shipment) map[string]interface{} {
+	state, ok := kuaidi100State[shipment.Status]
+	if !ok {
+		state = "0"
+	}
+	ischeck := "0"
+	if shipment.Status == expressTrace.StatusDelivered {
+		ischeck = "1"
+	}
+	var data = make([]map[string]interface{}, 0, len(shipment.Traces))
+	for _, t := range shipment.Traces {
+		data = append(data, map[string]interface{}{
+			"time":       t.Time,
+			"ftime":      t.Time,
+			"context":    t.Info,
+			"status":     t.StatusName,
+			"statusCode": t.StatusCode,
+			"areaCode":   nil,
+			"areaName":   nil,
+			"location":   "",
+		})
+	}
+	result := map[string]interface{}{
+		"message":   "ok",
+		"nu":        shipment.Number,
+		"com":       shipment.company(expressTrace.ProviderKuaidi100),
+		"status":    "200",
+		"state":     state,
+		"condition": "00",
+		"data":      data,
+		"isLoop":    false,
+	}
+	//暂无轨迹的推送(如3天无记录中止监控)不返回ischeck
+	if len(data) > 0 {
+		result["ischeck"] = ischeck
+	}
+	return result
+}
+
+// Callback 按快递100推送格式将运单POST到订阅时提交的callbackurl，推送方返回 result:true 时成功
+func (s *Kuaidi100Server) Callback(ctx context.Context, number string) error {
+	req, err := s.CallbackRequest(ctx, number)
+	if err != nil {
+		return err
+	}
+	code, body, err := do(req)
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Result     bool   `json:"result"`
+		ReturnCode string `json:"returnCode"`
+		Message    string `json:"message"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil || !resp.Result {
+		return fmt.Errorf("推送失败: %d %s", code, body)
+	}
+	return nil
+}
+
+// CallbackRequest 返回快递100格式的推送请求，使用订阅参数中的salt签名，终态运单的推送状态为shutdown
+func (s *Kuaidi100Server) CallbackRequest(ctx context.Context, number string) (*http.Request, error) {
+	shipment, sub, ok := s.callback(number)
+	if !ok {
+		return nil, errors.New("运单未订阅:" + number)
+	}
+
+	status := expressTrace.SubscriptionPolling
+	billStatus := ""
+	if shipment.Status.Terminal() {
+		status = expressTrace.SubscriptionShutdown
+	}
+	if shipment.Status == expressTrace.StatusDelivered {
+		billStatus = "check"
+	}
+	param, _ := json.Marshal(map[string]interface{}{
+		"status":     status,
+		"billstatus": billStatus,
+		"message":    "",
+		"lastResult": kuaidi100Result(shipment),
+	})
+	return formRequest(ctx, sub.url, url.Values{
+		"param": {string(param)},
+		"sign":  {sign(string(param) + sub.salt)},
+	})
+}
+
+func sign(s string) string {
+	hash := md5.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(hash[:]))
+}
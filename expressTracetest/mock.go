@@ -0,0 +1,108 @@
+// Package expressTracetest 提供测试用的 ExpressTrace 模拟实现与模拟福清、快递100接口的本地服务
+package expressTracetest
+
+import (
+	"context"
+	expressTrace "github.com/go-tron/express-trace"
+	"sync"
+)
+
+var _ expressTrace.ExpressTrace = (*Mock)(nil)
+
+// Mock 可编排的 ExpressTrace，未设置 *Func 时按单号返回 SetResult/SetError 预设的结果
+type Mock struct {
+	QueryFunc     func(context.Context, *expressTrace.QueryReq) (*expressTrace.SubscribeRes, error)
+	SubscribeFunc func(context.Context, *expressTrace.SubscribeReq) error
+	CallbackFunc  func(context.Context, int64, map[string]string) (*expressTrace.SubscribeRes, error)
+
+	mu            sync.Mutex
+	results       map[string]*expressTrace.SubscribeRes
+	errors        map[string]error
+	queries       []*expressTrace.QueryReq
+	subscriptions []*expressTrace.SubscribeReq
+}
+
+func NewMock() *Mock {
+	return &Mock{
+		results: make(map[string]*expressTrace.SubscribeRes),
+		errors:  make(map[string]error),
+	}
+}
+
+func (m *Mock) SetResult(res *expressTrace.SubscribeRes) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.results[res.Number] = res
+}
+
+func (m *Mock) SetError(number string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors[number] = err
+}
+
+// Queries 返回所有 Query 请求
+func (m *Mock) Queries() []*expressTrace.QueryReq {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*expressTrace.QueryReq(nil), m.queries...)
+}
+
+// Subscriptions 返回所有 Subscribe 请求
+func (m *Mock) Subscriptions() []*expressTrace.SubscribeReq {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*expressTrace.SubscribeReq(nil), m.subscriptions...)
+}
+
+func (m *Mock) result(orderId int64, number string) (*expressTrace.SubscribeRes, error) {
+	if err := m.errors[number]; err != nil {
+		return nil, err
+	}
+	if res, ok := m.results[number]; ok {
+		c := *res
+		c.OrderId = orderId
+		c.Traces = append([]expressTrace.Trace(nil), res.Traces...)
+		return &c, nil
+	}
+	return &expressTrace.SubscribeRes{
+		OrderId: orderId,
+		Number:  number,
+		Status:  expressTrace.StatusPending,
+		Traces:  make([]expressTrace.Trace, 0),
+	}, nil
+}
+
+func (m *Mock) Query(ctx context.Context, req *expressTrace.QueryReq) (*expressTrace.SubscribeRes, error) {
+	m.mu.Lock()
+	m.queries = append(m.queries, req)
+	m.mu.Unlock()
+	if m.QueryFunc != nil {
+		return m.QueryFunc(ctx, req)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.result(req.OrderId, req.Number)
+}
+
+func (m *Mock) Subscribe(ctx context.Context, req *expressTrace.SubscribeReq) error {
+	m.mu.Lock()
+	m.subscriptions = append(m.subscriptions, req)
+	m.mu.Unlock()
+	if m.SubscribeFunc != nil {
+		return m.SubscribeFunc(ctx, req)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.errors[req.Number]
+}
+
+// SubscribeCallback 默认按 data["number"] 返回预设结果
+func (m *Mock) SubscribeCallback(ctx context.Context, orderId int64, data map[string]string) (*expressTrace.SubscribeRes, error) {
+	if m.CallbackFunc != nil {
+		return m.CallbackFunc(ctx, orderId, data)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.result(orderId, data["number"])
+}
@@ -0,0 +1,36 @@
+package expressTracetest
+
+import (
+	"context"
+	"errors"
+	expressTrace "github.com/go-tron/express-trace"
+	"testing"
+)
+
+func TestMock(t *testing.T) {
+	mock := NewMock()
+	mock.SetResult(&expressTrace.SubscribeRes{
+		Number: "JD0076810060555",
+		Status: expressTrace.StatusDelivered,
+	})
+	mock.SetError("JD0076810087472", errors.New("fail"))
+
+	res, err := mock.Query(context.Background(), &expressTrace.QueryReq{OrderId: 1, Number: "JD0076810060555"})
+	if err != nil || res.OrderId != 1 || res.Status != expressTrace.StatusDelivered {
+		t.Fatalf("unexpected result %+v %v", res, err)
+	}
+	if res, err := mock.Query(context.Background(), &expressTrace.QueryReq{Number: "SF1234567890"}); err != nil || res.Status != expressTrace.StatusPending {
+		t.Fatalf("unexpected result %+v %v", res, err)
+	}
+	if err := mock.Subscribe(context.Background(), &expressTrace.SubscribeReq{OrderId: 2, Number: "JD0076810087472"}); err == nil {
+		t.Fatal("expected error")
+	}
+	if len(mock.Queries()) != 2 || len(mock.Subscriptions()) != 1 {
+		t.Fatalf("queries %d, subscriptions %d", len(mock.Queries()), len(mock.Subscriptions()))
+	}
+
+	res, err = mock.SubscribeCallback(context.Background(), 3, map[string]string{"number": "JD0076810060555"})
+	if err != nil || res.OrderId != 3 || res.Number != "JD0076810060555" {
+		t.Fatalf("unexpected result %+v %v", res, err)
+	}
+}
@@ -0,0 +1,307 @@
+package expressTracetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Redacted 脱敏后的取值
+const Redacted = "***"
+
+// DefaultSecrets 默认脱敏的参数名，同时作用于查询参数、表单字段、JSON字段以及参数中嵌套的url
+var DefaultSecrets = []string{"key", "customer", "sign", "token", "salt"}
+
+// Interaction 一次录制的请求与响应
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	Url    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body"`
+}
+
+// Recorder 录制回放用的 http.RoundTripper：golden文件不存在时经Transport发出真实请求并保存脱敏后的请求与响应，
+// 存在时按方法、url与请求体匹配录制结果回放，不再访问网络
+type Recorder struct {
+	Path      string
+	Transport http.RoundTripper //录制时使用，默认 http.DefaultTransport
+	Secrets   []string          //脱敏的参数名，默认 DefaultSecrets
+	Update    bool              //忽略已有的golden文件重新录制
+
+	once         sync.Once
+	mu           sync.Mutex
+	recording    bool
+	interactions []Interaction
+	used         []bool
+	err          error
+}
+
+func NewRecorder(path string) *Recorder {
+	return &Recorder{Path: path}
+}
+
+// Client 返回使用该Recorder的 http.Client，可直接设置为服务商的HttpClient
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// Recording 是否处于录制模式
+func (r *Recorder) Recording() bool {
+	r.load()
+	return r.recording
+}
+
+func (r *Recorder) load() {
+	r.once.Do(func() {
+		body, err := os.ReadFile(r.Path)
+		if r.Update || errors.Is(err, os.ErrNotExist) {
+			r.recording = true
+			return
+		}
+		if err != nil {
+			r.err = err
+			return
+		}
+		if err := json.Unmarshal(body, &r.interactions); err != nil {
+			r.err = err
+			return
+		}
+		r.used = make([]bool, len(r.interactions))
+	})
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.load()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	recorded := r.sanitizeRequest(req, body)
+
+	if r.recording {
+		return r.record(req, recorded)
+	}
+	return r.replay(req, recorded)
+}
+
+func (r *Recorder) record(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	header := resp.Header.Clone()
+	header.Del("Set-Cookie")
+	header.Del("Date")
+	header.Del("Content-Length")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions = append(r.interactions, Interaction{
+		Request: recorded,
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     header,
+			Body:       r.sanitizeString(string(body)),
+		},
+	})
+	if err := r.save(); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *Recorder) save() error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(r.interactions); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.Path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(r.Path, buf.Bytes(), 0644)
+}
+
+func (r *Recorder) replay(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, v := range r.interactions {
+		if r.used[i] || v.Request.Method != recorded.Method || v.Request.Url != recorded.Url || v.Request.Body != recorded.Body {
+			continue
+		}
+		r.used[i] = true
+		return &http.Response{
+			Status:        http.StatusText(v.Response.StatusCode),
+			StatusCode:    v.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        v.Response.Header.Clone(),
+			Body:          io.NopCloser(strings.NewReader(v.Response.Body)),
+			ContentLength: int64(len(v.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, errors.New("没有匹配的录制请求: " + recorded.Method + " " + recorded.Url + " " + recorded.Body)
+}
+
+func (r *Recorder) secret(name string) bool {
+	secrets := r.Secrets
+	if secrets == nil {
+		secrets = DefaultSecrets
+	}
+	for _, v := range secrets {
+		if strings.EqualFold(v, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Recorder) sanitizeRequest(req *http.Request, body []byte) RecordedRequest {
+	u := *req.URL
+	u.RawQuery = r.sanitizeValues(u.Query()).Encode()
+
+	header := req.Header.Clone()
+	if auth := header.Get("Authorization"); auth != "" {
+		//保留认证方式，如 APPCODE ***
+		if i := strings.Index(auth, " "); i > 0 {
+			header.Set("Authorization", auth[:i+1]+Redacted)
+		} else {
+			header.Set("Authorization", Redacted)
+		}
+	}
+	header.Del("User-Agent")
+
+	recorded := RecordedRequest{
+		Method: req.Method,
+		Url:    u.String(),
+		Header: header,
+	}
+	if len(body) > 0 {
+		recorded.Body = string(body)
+		if strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+			if form, err := url.ParseQuery(string(body)); err == nil {
+				recorded.Body = r.sanitizeValues(form).Encode()
+			}
+		}
+	}
+	return recorded
+}
+
+func (r *Recorder) sanitizeValues(values url.Values) url.Values {
+	var res = make(url.Values, len(values))
+	for name, list := range values {
+		for _, v := range list {
+			if r.secret(name) {
+				v = Redacted
+			} else {
+				v = r.sanitizeString(v)
+			}
+			res.Add(name, v)
+		}
+	}
+	return res
+}
+
+// sanitizeString 参数值为JSON或url时脱敏其中的字段，如快递100的param与福清推送地址中的token，不含敏感字段时保持原样
+func (r *Recorder) sanitizeString(s string) string {
+	trimmed := strings.TrimSpace(s)
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		var v interface{}
+		decoder := json.NewDecoder(strings.NewReader(s))
+		decoder.UseNumber()
+		if err := decoder.Decode(&v); err == nil {
+			changed := false
+			v = r.sanitizeJSON(v, &changed)
+			if !changed {
+				return s
+			}
+			return marshal(v)
+		}
+	}
+	if strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") {
+		if u, err := url.Parse(s); err == nil && u.RawQuery != "" {
+			query := r.sanitizeValues(u.Query()).Encode()
+			if query == u.Query().Encode() {
+				return s
+			}
+			u.RawQuery = query
+			return u.String()
+		}
+	}
+	return s
+}
+
+func (r *Recorder) sanitizeJSON(v interface{}, changed *bool) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for name, value := range v {
+			if r.secret(name) {
+				v[name] = Redacted
+				*changed = true
+			} else {
+				v[name] = r.sanitizeJSON(value, changed)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = r.sanitizeJSON(v[i], changed)
+		}
+	case string:
+		if res := r.sanitizeString(v); res != v {
+			*changed = true
+			return res
+		}
+	}
+	return v
+}
+
+// marshal 不转义HTML字符，保持golden文件可读
+func marshal(v interface{}) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(v)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
@@ -0,0 +1,70 @@
+package expressTracetest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"result":true,"path":"` + r.URL.Path + `"}`))
+	}))
+	path := filepath.Join(t.TempDir(), "golden.json")
+
+	send := func(client *http.Client) []string {
+		var res []string
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/expresspush?no=JD0076810087472&url="+url.QueryEscape("http://example.com/fuqing?orderId=1&token=secret-token"), nil)
+		req.Header.Set("Authorization", "APPCODE secret-appcode")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		res = append(res, string(body))
+
+		resp, err = client.PostForm(server.URL+"/poll/query.do", url.Values{
+			"customer": {"secret-customer"},
+			"sign":     {"secret-sign"},
+			"param":    {`{"num":"JD0076810060555","parameters":{"key":"secret-key","salt":"secret-salt"}}`},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ = io.ReadAll(resp.Body)
+		return append(res, string(body))
+	}
+
+	recorder := NewRecorder(path)
+	if !recorder.Recording() {
+		t.Fatal("expected recording")
+	}
+	recorded := send(recorder.Client())
+	server.Close()
+
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(golden), "secret") {
+		t.Fatalf("secret not scrubbed:\n%s", golden)
+	}
+
+	recorder = NewRecorder(path)
+	if recorder.Recording() {
+		t.Fatal("expected replay")
+	}
+	replayed := send(recorder.Client())
+	if strings.Join(replayed, "\n") != strings.Join(recorded, "\n") {
+		t.Fatalf("replayed %v, recorded %v", replayed, recorded)
+	}
+
+	if _, err := recorder.Client().Get(server.URL + "/kdi?no=1"); err == nil {
+		t.Fatal("expected unmatched request error")
+	}
+}
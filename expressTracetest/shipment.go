@@ -0,0 +1,136 @@
+package expressTracetest
+
+import (
+	expressTrace "github.com/go-tron/express-trace"
+	"sync"
+)
+
+// 模拟服务商返回的错误码，福清与快递100均使用
+const (
+	CodeWrongNumber = "201" //快递单号错误
+	CodeNoCompany   = "203" //快递公司不存在
+	CodeNoInfo      = "205" //没有信息
+	CodeLimited     = "207" //该单号被限制，错误单号
+)
+
+var codeMessage = map[string]string{
+	CodeWrongNumber: "快递单号错误",
+	CodeNoCompany:   "快递公司不存在",
+	CodeNoInfo:      "没有信息",
+	CodeLimited:     "该单号被限制，错误单号",
+}
+
+// CodeMessage 返回错误码对应的提示信息
+func CodeMessage(code string) string {
+	if msg, ok := codeMessage[code]; ok {
+		return msg
+	}
+	return "请求失败"
+}
+
+// Shipment 模拟服务商中的运单
+type Shipment struct {
+	Number  string
+	Company string              //统一快递公司编码，模拟服务器按 Carrier.Codes 转换为服务商编码
+	Status  expressTrace.Status //统一状态，模拟服务器转换为服务商的原始状态码
+	Traces  []Trace             //按时间倒序
+}
+
+type Trace struct {
+	Time       string //2006-01-02 15:04:05
+	Info       string
+	StatusCode string //快递100子状态码
+	StatusName string //快递100状态名称
+}
+
+func (s *Shipment) company(provider string) string {
+	return expressTrace.ProviderCompany(provider, s.Company)
+}
+
+func (s *Shipment) lastTime() string {
+	if len(s.Traces) == 0 {
+		return ""
+	}
+	return s.Traces[0].Time
+}
+
+// shipments 模拟服务器共用的运单、错误码与订阅记录
+type shipments struct {
+	mu            sync.Mutex
+	items         map[string]*Shipment
+	errors        map[string]string
+	subscriptions map[string]subscription
+}
+
+type subscription struct {
+	url  string
+	salt string
+}
+
+func newShipments() *shipments {
+	return &shipments{
+		items:         make(map[string]*Shipment),
+		errors:        make(map[string]string),
+		subscriptions: make(map[string]subscription),
+	}
+}
+
+// SetShipment 添加或替换运单
+func (s *shipments) SetShipment(shipment *Shipment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[shipment.Number] = shipment
+}
+
+// SetError 使该单号的查询与订阅返回code对应的错误，code为空时取消
+func (s *shipments) SetError(number string, code string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if code == "" {
+		delete(s.errors, number)
+		return
+	}
+	s.errors[number] = code
+}
+
+// Subscribed 返回该单号订阅时提交的推送地址
+func (s *shipments) Subscribed(number string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subscriptions[number]
+	return sub.url, ok
+}
+
+func (s *shipments) lookup(number string) (*Shipment, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if code := s.errors[number]; code != "" {
+		return nil, code
+	}
+	shipment, ok := s.items[number]
+	if !ok {
+		return nil, CodeNoInfo
+	}
+	return shipment, ""
+}
+
+func (s *shipments) subscribe(number string, sub subscription) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if code := s.errors[number]; code != "" {
+		return code
+	}
+	s.subscriptions[number] = sub
+	return ""
+}
+
+func (s *shipments) callback(number string) (*Shipment, subscription, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subscriptions[number]
+	if !ok {
+		return nil, sub, false
+	}
+	shipment, ok := s.items[number]
+	return shipment, sub, ok
+}
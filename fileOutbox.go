@@ -0,0 +1,76 @@
+package expressTrace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+)
+
+var _ Outbox = (*FileOutbox)(nil)
+
+// FileOutbox 每次变化后将全部待发送条目以JSON写入文件，适合单实例部署
+type FileOutbox struct {
+	*MemoryOutbox
+	path    string
+	writeMu sync.Mutex
+}
+
+func NewFileOutbox(path string) (*FileOutbox, error) {
+	o := &FileOutbox{
+		MemoryOutbox: NewMemoryOutbox(),
+		path:         path,
+	}
+	body, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []*OutboxEntry
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range list {
+		o.MemoryOutbox.put(e)
+	}
+	return o, nil
+}
+
+func (o *FileOutbox) Put(ctx context.Context, e *OutboxEntry) error {
+	o.writeMu.Lock()
+	defer o.writeMu.Unlock()
+	o.mu.Lock()
+	changed := o.put(e)
+	o.mu.Unlock()
+	if !changed {
+		return nil
+	}
+	return o.flush()
+}
+
+func (o *FileOutbox) Delete(ctx context.Context, e *OutboxEntry) error {
+	o.writeMu.Lock()
+	defer o.writeMu.Unlock()
+	o.mu.Lock()
+	changed := o.delete(e)
+	o.mu.Unlock()
+	if !changed {
+		return nil
+	}
+	return o.flush()
+}
+
+func (o *FileOutbox) flush() error {
+	o.mu.RLock()
+	body, err := json.Marshal(o.all())
+	o.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return writeFile(o.path, body)
+}
@@ -0,0 +1,138 @@
+package expressTrace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+var (
+	_ Store   = (*FileStore)(nil)
+	_ Updater = (*FileStore)(nil)
+	_ Pinger  = (*FileStore)(nil)
+)
+
+// FileStore 在内存中保存运单，每次保存后将全部运单以JSON写入文件，适合单实例部署
+type FileStore struct {
+	*MemoryStore
+	path    string
+	writeMu sync.Mutex
+	err     error //最近一次写入文件的错误
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		MemoryStore: NewMemoryStore(),
+		path:        path,
+	}
+	body, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []storedShipment
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range list {
+		if err := s.MemoryStore.Save(context.Background(), v.SubscribeRes); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *FileStore) Save(ctx context.Context, res *SubscribeRes) error {
+	if res.OrderId == 0 {
+		return ErrorSubscription(res.Number)
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.commit(res)
+}
+
+// Update 在写锁内合并运单后写入文件
+func (s *FileStore) Update(ctx context.Context, orderId int64, number string, fn func(old *SubscribeRes) (*SubscribeRes, error)) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.mu.RLock()
+	old := s.find(orderId, number)
+	s.mu.RUnlock()
+	res, err := fn(old)
+	if err != nil {
+		return err
+	}
+	if res.OrderId == 0 {
+		return ErrorSubscription(res.Number)
+	}
+	return s.commit(res)
+}
+
+// Ping 返回最近一次写入文件的错误，如磁盘已满
+func (s *FileStore) Ping(ctx context.Context) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.err
+}
+
+// commit 先将包含res的全部运单写入文件，成功后再更新内存，写入失败时内存保持不变，重试时仍能合并出变化
+func (s *FileStore) commit(res *SubscribeRes) error {
+	s.mu.RLock()
+	var list = make([]*SubscribeRes, 0, len(s.data)+1)
+	for _, v := range s.data {
+		if v.OrderId != res.OrderId {
+			list = append(list, v)
+		}
+	}
+	list = append(list, res)
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].OrderId < list[j].OrderId
+	})
+	body, err := marshalShipments(list)
+	s.mu.RUnlock()
+	if err == nil {
+		err = writeFile(s.path, body)
+	}
+	s.err = err
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.put(res)
+	s.mu.Unlock()
+	return nil
+}
+
+func marshalShipments(list []*SubscribeRes) ([]byte, error) {
+	var stored = make([]storedShipment, 0, len(list))
+	for _, v := range list {
+		stored = append(stored, storedShipment{v})
+	}
+	return json.Marshal(stored)
+}
+
+// writeFile 先写入临时文件再重命名，避免进程中断时文件损坏
+func writeFile(path string, body []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,105 @@
+package fuqing
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+type Company struct {
+	Type string `json:"type"` //快递公司编码
+	Name string `json:"name"`
+	Logo string `json:"logo"`
+	Push bool   `json:"push"` //是否支持推送订阅
+}
+
+type CompanyResponse struct {
+	Status  bool      `json:"status"`
+	Message string    `json:"message"`
+	Result  []Company `json:"result"`
+}
+
+type companyCache struct {
+	mu        sync.RWMutex
+	list      []Company
+	updatedAt time.Time
+}
+
+// Company 返回支持推送的快递公司列表，结果缓存 CompanyTTL
+func (c *Fuqing) Company(ctx context.Context) ([]Company, error) {
+	c.companies.mu.RLock()
+	list, updatedAt := c.companies.list, c.companies.updatedAt
+	c.companies.mu.RUnlock()
+	if list != nil && time.Since(updatedAt) < c.CompanyTTL {
+		return append([]Company(nil), list...), nil
+	}
+	return c.RefreshCompany(ctx)
+}
+
+// RefreshCompany 忽略缓存重新获取快递公司列表
+func (c *Fuqing) RefreshCompany(ctx context.Context) (res []Company, err error) {
+
+	var resBody = ""
+	defer func() {
+		c.Logger.Info("",
+			c.Logger.Field("method", "pushExpressLists"),
+			c.Logger.Field("error", err),
+			c.Logger.Field("response", resBody),
+		)
+	}()
+
+	request := c.Client.R().SetContext(ctx)
+	request = request.SetHeaders(map[string]string{
+		"Authorization": "APPCODE " + c.AppCode,
+	})
+	response, err := request.Get(c.PushBaseUrl + "/pushExpressLists")
+	if err != nil {
+		return nil, ErrorRequest(err)
+	}
+	resBody = response.String()
+	var resp CompanyResponse
+	if err := json.Unmarshal(response.Body(), &resp); err != nil {
+		return nil, ErrorResponse(err)
+	}
+
+	if !resp.Status {
+		var errorMsg = "请求失败"
+		if resp.Message != "" {
+			errorMsg = resp.Message
+		}
+		return nil, ErrorFail(errorMsg)
+	}
+
+	//pushExpressLists 仅返回支持推送的快递公司
+	res = make([]Company, 0, len(resp.Result))
+	for _, v := range resp.Result {
+		v.Push = true
+		res = append(res, v)
+	}
+
+	c.companies.mu.Lock()
+	c.companies.list = res
+	c.companies.updatedAt = time.Now()
+	c.companies.mu.Unlock()
+
+	return append([]Company(nil), res...), nil
+}
+
+// StartCompanyRefresh 后台每隔 CompanyTTL 刷新快递公司列表，ctx结束后停止
+func (c *Fuqing) StartCompanyRefresh(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(c.CompanyTTL)
+		defer ticker.Stop()
+		for {
+			if _, err := c.RefreshCompany(ctx); err != nil {
+				c.Logger.Error("刷新快递公司列表失败", c.Logger.Field("error", err))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -1,15 +1,23 @@
 package fuqing
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"github.com/go-playground/validator/v10"
 	"github.com/go-resty/resty/v2"
 	baseError "github.com/go-tron/base-error"
 	"github.com/go-tron/config"
 	expressTrace "github.com/go-tron/express-trace"
+	"github.com/go-tron/express-trace/parser"
 	localTime "github.com/go-tron/local-time"
 	"github.com/go-tron/logger"
+	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 )
 
 var (
@@ -18,6 +26,7 @@ var (
 	ErrorResponse       = baseError.SystemFactory("3013", "快递查询服务返回失败:{}")
 	ErrorFail           = baseError.SystemFactory("3014")
 	ErrorCallbackParams = baseError.Factory("3015", "缺少参数")
+	ErrorSign           = baseError.New("3016", "签名验证失败")
 )
 
 const (
@@ -46,18 +55,75 @@ func StateCode(code string) string {
 	return stateCode[code]
 }
 
+var stateStatus = map[string]expressTrace.Status{
+	StateWrong:     expressTrace.StatusInvalid,
+	StateNoneYet:   expressTrace.StatusPending,
+	StateAccepted:  expressTrace.StatusAccepted,
+	StateInTransit: expressTrace.StatusInTransit,
+	StateDelivered: expressTrace.StatusDelivered,
+	StateQuestion:  expressTrace.StatusException,
+	StateException: expressTrace.StatusException,
+	StateReturned:  expressTrace.StatusReturned,
+}
+
+// Status 推送回调的state转换为统一状态
+func Status(state string) expressTrace.Status {
+	if s, ok := stateStatus[state]; ok {
+		return s
+	}
+	return expressTrace.StatusUnknown
+}
+
+// 实时查询接口的deliverystatus与推送的state编码不同
+var deliveryStatus = map[string]expressTrace.Status{
+	"0": expressTrace.StatusAccepted,   //快递收件(揽件)
+	"1": expressTrace.StatusInTransit,  //在途中
+	"2": expressTrace.StatusDelivering, //正在派件
+	"3": expressTrace.StatusDelivered,  //已签收
+	"4": expressTrace.StatusException,  //派送失败
+	"5": expressTrace.StatusException,  //疑难件
+	"6": expressTrace.StatusReturned,   //退件签收
+}
+
+// DeliveryStatus 实时查询的deliverystatus转换为统一状态
+func DeliveryStatus(code string) expressTrace.Status {
+	if s, ok := deliveryStatus[code]; ok {
+		return s
+	}
+	return expressTrace.StatusUnknown
+}
+
+var _ expressTrace.ExpressTrace = (*Fuqing)(nil)
+
 var validate *validator.Validate
 
 func init() {
 	validate = validator.New()
 }
 
+const (
+	DefaultQueryBaseUrl = "http://wuliu.market.alicloudapi.com"
+	DefaultPushBaseUrl  = "http://expfeeds.market.alicloudapi.com"
+	DefaultTimeout      = 10 * time.Second
+	DefaultCompanyTTL   = 24 * time.Hour
+)
+
 type Fuqing struct {
 	AppKey       string
 	AppSecret    string
 	AppCode      string
 	SubscribeUrl string
+	TokenSecret  string                    //推送地址携带的token密钥，用于验证推送来源，为空时不验证
+	AllowNoToken bool                      //允许不带token的推送并记录警告，用于设置TokenSecret前已订阅的运单
+	QueryBaseUrl string                    //实时查询接口地址，默认 DefaultQueryBaseUrl
+	PushBaseUrl  string                    //推送订阅接口地址，默认 DefaultPushBaseUrl
+	HttpClient   *http.Client              //Client为空时使用该http.Client创建
+	Client       *resty.Client             //多个实例可共享同一个Client以复用连接
+	TimeZone     *time.Location            //轨迹时间所在时区，默认 expressTrace.DefaultTimeZone
+	TimeZones    map[string]*time.Location //按快递公司编码指定时区，用于国际快递
+	CompanyTTL   time.Duration             //快递公司列表缓存时间，默认 DefaultCompanyTTL
 	Logger       logger.Logger
+	companies    companyCache
 }
 
 func NewWithConfig(c *config.Config) *Fuqing {
@@ -66,10 +132,36 @@ func NewWithConfig(c *config.Config) *Fuqing {
 		AppSecret:    c.GetString("fuqing.appSecret"),
 		AppCode:      c.GetString("fuqing.appCode"),
 		SubscribeUrl: c.GetString("fuqing.subscribeUrl"),
+		TokenSecret:  c.GetString("fuqing.tokenSecret"),
+		AllowNoToken: c.GetBool("fuqing.allowNoToken"),
+		QueryBaseUrl: c.GetString("fuqing.queryBaseUrl"),
+		PushBaseUrl:  c.GetString("fuqing.pushBaseUrl"),
+		HttpClient:   httpClientWithConfig(c),
+		TimeZone:     expressTrace.MustTimeZone(c.GetString("fuqing.timezone")),
+		TimeZones:    expressTrace.MustTimeZones(c.GetStringMapString("fuqing.timezones")),
+		CompanyTTL:   c.GetDuration("fuqing.companyTTL"),
 		Logger:       logger.NewZapWithConfig(c, "fuqing", "error"),
 	})
 }
 
+func httpClientWithConfig(c *config.Config) *http.Client {
+	timeout := c.GetDuration("fuqing.timeout")
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+	if proxy := c.GetString("fuqing.proxy"); proxy != "" {
+		proxyUrl, err := url.Parse(proxy)
+		if err != nil {
+			panic("proxy 格式错误")
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(proxyUrl)
+		client.Transport = transport
+	}
+	return client
+}
+
 func New(c *Fuqing) *Fuqing {
 	if c == nil {
 		panic("config 必须设置")
@@ -89,6 +181,25 @@ func New(c *Fuqing) *Fuqing {
 	if c.Logger == nil {
 		panic("Logger 必须设置")
 	}
+	if c.TokenSecret == "" {
+		c.Logger.Warn("TokenSecret 未设置，推送不验证来源")
+	}
+	if c.QueryBaseUrl == "" {
+		c.QueryBaseUrl = DefaultQueryBaseUrl
+	}
+	if c.PushBaseUrl == "" {
+		c.PushBaseUrl = DefaultPushBaseUrl
+	}
+	if c.CompanyTTL == 0 {
+		c.CompanyTTL = DefaultCompanyTTL
+	}
+	if c.Client == nil {
+		if c.HttpClient != nil {
+			c.Client = resty.NewWithClient(c.HttpClient)
+		} else {
+			c.Client = resty.New().SetTimeout(DefaultTimeout)
+		}
+	}
 	return c
 }
 
@@ -97,30 +208,103 @@ type QueryReq struct {
 	Type string `json:"type"`
 }
 type QueryResponse struct {
-	Status string   `json:"status"` //status 0:正常查询 201:快递单号错误 203:快递公司不存在 204:快递公司识别失败 205:没有信息 207:该单号被限制，错误单号
-	Msg    string   `json:"msg"`
-	Result QueryRes `json:"result"`
+	Status expressTrace.LooseString `json:"status"` //status 0:正常查询 201:快递单号错误 203:快递公司不存在 204:快递公司识别失败 205:没有信息 207:该单号被限制，错误单号
+	Msg    string                   `json:"msg"`
+	Result QueryRes                 `json:"result"`
 }
 type QueryRes struct {
-	Number         string          `json:"number"`         //快递单号
-	Type           string          `json:"type"`           //快递缩写
-	Deliverystatus string          `json:"deliverystatus"` //0：快递收件(揽件)1.在途中 2.正在派件 3.已签收 4.派送失败 5.疑难件 6.退件签收
-	Issign         string          `json:"issign"`         //是否签收
-	ExpName        string          `json:"expName"`        //快递公司名称
-	ExpSite        string          `json:"expSite"`        //快递公司官网
-	ExpPhone       string          `json:"expPhone"`       //快递公司电话
-	Logo           string          `json:"logo"`           //快递公司LOGO
-	Courier        string          `json:"courier"`        //快递员
-	CourierPhone   string          `json:"courierPhone"`   //快递员电话
-	UpdateTime     *localTime.Time `json:"updateTime"`     //快递轨迹信息最新时间
-	TakeTime       string          `json:"takeTime"`       //发货到收货消耗时长
+	Number         string                   `json:"number"`         //快递单号
+	Type           string                   `json:"type"`           //快递缩写
+	Deliverystatus expressTrace.LooseString `json:"deliverystatus"` //0：快递收件(揽件)1.在途中 2.正在派件 3.已签收 4.派送失败 5.疑难件 6.退件签收
+	Issign         expressTrace.LooseString `json:"issign"`         //是否签收
+	ExpName        string                   `json:"expName"`        //快递公司名称
+	ExpSite        string                   `json:"expSite"`        //快递公司官网
+	ExpPhone       string                   `json:"expPhone"`       //快递公司电话
+	Logo           string                   `json:"logo"`           //快递公司LOGO
+	Courier        string                   `json:"courier"`        //快递员
+	CourierPhone   string                   `json:"courierPhone"`   //快递员电话
+	UpdateTime     *localTime.Time          `json:"updateTime"`     //快递轨迹信息最新时间
+	TakeTime       string                   `json:"takeTime"`       //发货到收货消耗时长
 	List           []struct {
 		Time   *localTime.Time `json:"time"`
 		Status string          `json:"status"`
 	} `json:"list"` //结果集
 }
 
-func (c *Fuqing) Query(req *QueryReq) (res *QueryRes, err error) {
+func (c *Fuqing) Query(ctx context.Context, req *expressTrace.QueryReq) (*expressTrace.SubscribeRes, error) {
+	if err := validate.Struct(req); err != nil {
+		return nil, ErrorParam(err)
+	}
+
+	result, err := c.QueryRaw(ctx, &QueryReq{
+		No:   req.Number,
+		Type: c.company(req.GuessCompany()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	signed := 0
+	if result.Issign == "1" || result.Deliverystatus == "3" {
+		signed = 1
+	}
+
+	var traces = make([]expressTrace.Trace, 0)
+	for _, v := range result.List {
+		//list中的null解析为空节点
+		if v.Time == nil && v.Status == "" {
+			continue
+		}
+		traces = append(traces, expressTrace.Trace{
+			Time:     v.Time,
+			Info:     v.Status,
+			Location: location(v.Status),
+		})
+	}
+
+	number := result.Number
+	if number == "" {
+		number = req.Number
+	}
+
+	res := &expressTrace.SubscribeRes{
+		OrderId:      req.OrderId,
+		Number:       number,
+		Signed:       signed,
+		Status:       DeliveryStatus(string(result.Deliverystatus)),
+		State:        string(result.Deliverystatus),
+		Courier:      result.Courier,
+		CourierPhone: result.CourierPhone,
+		UpdateTime:   result.UpdateTime,
+		Traces:       traces,
+		CompanyName:  result.ExpName,
+		CompanySite:  result.ExpSite,
+		CompanyPhone: result.ExpPhone,
+		CompanyLogo:  result.Logo,
+	}
+	res.TakeTime, _ = expressTrace.ParseTakeTime(result.TakeTime)
+	expressTrace.FillCompany(res, expressTrace.ProviderFuqing, result.Type)
+	expressTrace.Normalize(res, expressTrace.TimeZone(c.TimeZones, res.CompanyCode, c.TimeZone))
+	expressTrace.FillTiming(res)
+	parser.Annotate(res)
+	return res, nil
+}
+
+// location 福清不返回节点位置，从轨迹中【】内的网点名称提取
+func location(info string) *expressTrace.Location {
+	facility := expressTrace.TraceFacility(info)
+	if facility == "" {
+		return nil
+	}
+	return &expressTrace.Location{Facility: facility}
+}
+
+// company 统一编码转换为福清编码，未指定时按单号识别
+func (c *Fuqing) company(company string) string {
+	return expressTrace.ProviderCompany(expressTrace.ProviderFuqing, company)
+}
+
+func (c *Fuqing) QueryRaw(ctx context.Context, req *QueryReq) (res *QueryRes, err error) {
 
 	var resBody = ""
 	defer func() {
@@ -144,14 +328,12 @@ func (c *Fuqing) Query(req *QueryReq) (res *QueryRes, err error) {
 	c.Logger.Info("开始请求",
 		c.Logger.Field("number", req.No),
 	)
-	url := "http://wuliu.market.alicloudapi.com/kdi"
-
-	request := resty.New().R()
+	request := c.Client.R().SetContext(ctx)
 	request = request.SetHeaders(map[string]string{
 		"Authorization": "APPCODE " + c.AppCode,
 	})
 	request = request.SetQueryParams(data)
-	response, err := request.Get(url)
+	response, err := request.Get(c.QueryBaseUrl + "/kdi")
 	if err != nil {
 		return nil, ErrorRequest(err)
 	}
@@ -183,25 +365,25 @@ type SubscribeResponse struct {
 }
 
 type SubscribeCallback struct {
-	Code         string          `json:"code"`         //-1单号或快递公司错误；201快递单号错误；203 快递公司不存在；204 错误单号重复；205 没有轨迹；207 该单号被限制，错误单号；OK 查询成功
-	No           string          `json:"no"`           //快递单号
-	Type         string          `json:"type"`         //快递缩写
-	State        string          `json:"state"`        //物流状态：-1：单号或代码错误；0：暂无轨迹；1:快递收件；2：在途中；3：签收；4：问题件 5.疑难件 6.退件签收
-	Name         string          `json:"name"`         //快递名称
-	Site         string          `json:"site"`         //快递公司官网
-	Phone        string          `json:"phone"`        //快递公司电话
-	Logo         string          `json:"logo"`         //快递公司logo
-	Courier      string          `json:"courier"`      //快递员
-	CourierPhone string          `json:"courierPhone"` //快递员电话
-	UpdateTime   *localTime.Time `json:"updateTime"`   //快递轨迹信息最新时间
-	TakeTime     string          `json:"takeTime"`     //发货到收货消耗时长
+	Code         expressTrace.LooseString `json:"code"`         //-1单号或快递公司错误；201快递单号错误；203 快递公司不存在；204 错误单号重复；205 没有轨迹；207 该单号被限制，错误单号；OK 查询成功
+	No           string                   `json:"no"`           //快递单号
+	Type         string                   `json:"type"`         //快递缩写
+	State        expressTrace.LooseString `json:"state"`        //物流状态：-1：单号或代码错误；0：暂无轨迹；1:快递收件；2：在途中；3：签收；4：问题件 5.疑难件 6.退件签收
+	Name         string                   `json:"name"`         //快递名称
+	Site         string                   `json:"site"`         //快递公司官网
+	Phone        string                   `json:"phone"`        //快递公司电话
+	Logo         string                   `json:"logo"`         //快递公司logo
+	Courier      string                   `json:"courier"`      //快递员
+	CourierPhone string                   `json:"courierPhone"` //快递员电话
+	UpdateTime   *localTime.Time          `json:"updateTime"`   //快递轨迹信息最新时间
+	TakeTime     string                   `json:"takeTime"`     //发货到收货消耗时长
 	List         []struct {
 		Time    *localTime.Time `json:"time"`
 		Content string          `json:"content"`
 	} `json:"list"` //结果集
 }
 
-func (c *Fuqing) Subscribe(req *expressTrace.SubscribeReq) (err error) {
+func (c *Fuqing) Subscribe(ctx context.Context, req *expressTrace.SubscribeReq) (err error) {
 
 	var resBody = ""
 	defer func() {
@@ -219,21 +401,22 @@ func (c *Fuqing) Subscribe(req *expressTrace.SubscribeReq) (err error) {
 	var data = make(map[string]string)
 	data["no"] = req.Number
 	data["url"] = c.SubscribeUrl + "?orderId=" + strconv.FormatInt(req.OrderId, 10)
-	if req.Company != "" {
-		data["type"] = req.Company
+	if c.TokenSecret != "" {
+		data["url"] += "&token=" + c.Token(req.OrderId, req.Number)
+	}
+	if company := c.company(req.GuessCompany()); company != "" {
+		data["type"] = company
 	}
 
 	c.Logger.Info("开始请求",
 		c.Logger.Field("number", req.Number),
 	)
-	url := "http://expfeeds.market.alicloudapi.com/expresspush"
-
-	request := resty.New().R()
+	request := c.Client.R().SetContext(ctx)
 	request = request.SetHeaders(map[string]string{
 		"Authorization": "APPCODE " + c.AppCode,
 	})
 	request = request.SetQueryParams(data)
-	response, err := request.Get(url)
+	response, err := request.Get(c.PushBaseUrl + "/expresspush")
 	if err != nil {
 		return ErrorRequest(err)
 	}
@@ -254,7 +437,7 @@ func (c *Fuqing) Subscribe(req *expressTrace.SubscribeReq) (err error) {
 	return nil
 }
 
-func (c *Fuqing) SubscribeCallback(orderId int64, data map[string]string) (res *expressTrace.SubscribeRes, err error) {
+func (c *Fuqing) SubscribeCallback(ctx context.Context, orderId int64, data map[string]string) (res *expressTrace.SubscribeRes, err error) {
 	if orderId == 0 {
 		return nil, ErrorCallbackParams("orderId")
 	}
@@ -263,6 +446,9 @@ func (c *Fuqing) SubscribeCallback(orderId int64, data map[string]string) (res *
 	}
 	callback := &SubscribeCallback{}
 	if err := json.Unmarshal([]byte(data["data"]), callback); err != nil {
+		return nil, ErrorResponse(err)
+	}
+	if err := c.verifyToken(orderId, callback.No, data["token"]); err != nil {
 		return nil, err
 	}
 
@@ -271,50 +457,67 @@ func (c *Fuqing) SubscribeCallback(orderId int64, data map[string]string) (res *
 		signed = 1
 	}
 
-	var lastTraceInfo = ""
-	var lastTraceTime *localTime.Time
-	if len(callback.List) > 0 {
-		lastTraceInfo = callback.List[0].Content
-		lastTraceTime = callback.List[0].Time
-	}
-
 	var traces = make([]expressTrace.Trace, 0)
 	for _, v := range callback.List {
+		//list中的null解析为空节点
+		if v.Time == nil && v.Content == "" {
+			continue
+		}
 		traces = append(traces, expressTrace.Trace{
-			Time: v.Time,
-			Info: v.Content,
+			Time:     v.Time,
+			Info:     v.Content,
+			Location: location(v.Content),
 		})
 	}
 
-	return &expressTrace.SubscribeRes{
-		OrderId:       orderId,
-		Number:        callback.No,
-		Signed:        signed,
-		Status:        StateCode(callback.State),
-		LastTraceInfo: lastTraceInfo,
-		LastTraceTime: lastTraceTime,
-		Traces:        traces,
-		CompanyName:   callback.Name,
-		CompanyCode:   callback.Type,
-		CompanySite:   callback.Site,
-		CompanyPhone:  callback.Phone,
-		CompanyLogo:   callback.Logo,
-	}, nil
+	res = &expressTrace.SubscribeRes{
+		OrderId:      orderId,
+		Number:       callback.No,
+		Signed:       signed,
+		Status:       Status(string(callback.State)),
+		State:        string(callback.State),
+		Courier:      callback.Courier,
+		CourierPhone: callback.CourierPhone,
+		UpdateTime:   callback.UpdateTime,
+		Traces:       traces,
+		CompanyName:  callback.Name,
+		CompanySite:  callback.Site,
+		CompanyPhone: callback.Phone,
+		CompanyLogo:  callback.Logo,
+	}
+	res.TakeTime, _ = expressTrace.ParseTakeTime(callback.TakeTime)
+	expressTrace.FillCompany(res, expressTrace.ProviderFuqing, callback.Type)
+	expressTrace.Normalize(res, expressTrace.TimeZone(c.TimeZones, res.CompanyCode, c.TimeZone))
+	expressTrace.FillTiming(res)
+	parser.Annotate(res)
+	return res, nil
 }
 
-func (c *Fuqing) Company() (res map[string]interface{}, err error) {
-	url := "http://expfeeds.market.alicloudapi.com/pushExpressLists"
-	request := resty.New().R()
-	request = request.SetHeaders(map[string]string{
-		"Authorization": "APPCODE " + c.AppCode,
-	})
-	response, err := request.Get(url)
-	if err != nil {
-		return nil, ErrorRequest(err)
+// Token 订阅时附加在推送地址上的签名，绑定orderId与快递单号，防止伪造推送。
+// 单号经 expressTrace.NormalizeNumber 处理，推送中的单号大小写、空白与提交时不同时仍可验证
+func (c *Fuqing) Token(orderId int64, number string) string {
+	mac := hmac.New(sha256.New, []byte(c.TokenSecret))
+	mac.Write([]byte(strconv.FormatInt(orderId, 10) + ":" + expressTrace.NormalizeNumber(number)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyToken TokenSecret为空时不验证；推送不带token时仅在 AllowNoToken 时放行并记录警告
+func (c *Fuqing) verifyToken(orderId int64, number string, token string) error {
+	if c.TokenSecret == "" {
+		return nil
 	}
-	res = make(map[string]interface{})
-	if err := json.Unmarshal(response.Body(), &res); err != nil {
-		return nil, ErrorResponse(err)
+	if token == "" {
+		if !c.AllowNoToken {
+			return ErrorCallbackParams("token")
+		}
+		c.Logger.Warn("推送未携带token",
+			c.Logger.Field("orderId", orderId),
+			c.Logger.Field("number", number),
+		)
+		return nil
 	}
-	return res, nil
+	if !hmac.Equal([]byte(token), []byte(c.Token(orderId, number))) {
+		return ErrorSign
+	}
+	return nil
 }
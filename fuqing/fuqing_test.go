@@ -1,46 +1,423 @@
 package fuqing
 
 import (
+	"context"
+	"encoding/json"
+	baseError "github.com/go-tron/base-error"
 	expressTrace "github.com/go-tron/express-trace"
+	"github.com/go-tron/express-trace/expressTracetest"
 	"github.com/go-tron/logger"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 )
 
-var fuqing = &Fuqing{
-	AppKey:       "204028321",
-	AppSecret:    "XRTq3KBzOZsnogXIUKxyJ6qgGwNLsQAY",
-	AppCode:      "e0d6240322de4170aed43c3f80818f28",
+var fuqing = New(&Fuqing{
+	AppKey:       "test-app-key",
+	AppSecret:    "test-app-secret",
+	AppCode:      "test-app-code",
 	SubscribeUrl: "http://express.eioos.com/fuqing",
+	TokenSecret:  "123",
 	Logger:       logger.NewZap("fuqing", "info"),
+})
+
+var shipment = &expressTracetest.Shipment{
+	Number:  "JD0076810087472",
+	Company: "jd",
+	Status:  expressTrace.StatusDelivered,
+	Traces: []expressTracetest.Trace{
+		{Time: "2022-06-30 10:34:52", Info: "您的快件已由快递驿站代收，感谢您使用京东物流，期待再次为您服务"},
+		{Time: "2022-06-30 08:06:02", Info: "您的快件正在派送中，请您准备签收（快递员：薛兵，联系电话：18740476340）。"},
+		{Time: "2022-06-30 07:18:05", Info: "您的快件已到达【西安兴善营业部】"},
+		{Time: "2022-06-29 15:38:09", Info: "您的快件已到达【西安灞桥分拣中心】"},
+	},
+}
+
+// newFake 返回连接模拟服务器的客户端，推送地址指向由fn处理的本地CallbackHandler
+func newFake(t *testing.T, fn expressTrace.CallbackFunc) (*Fuqing, *expressTracetest.FuqingServer) {
+	server := expressTracetest.NewFuqingServer(fuqing.AppCode)
+	t.Cleanup(server.Close)
+
+	var client *Fuqing
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client.CallbackHandler(fn).ServeHTTP(w, r)
+	}))
+	t.Cleanup(callback.Close)
+
+	client = New(&Fuqing{
+		AppKey:       fuqing.AppKey,
+		AppSecret:    fuqing.AppSecret,
+		AppCode:      fuqing.AppCode,
+		SubscribeUrl: callback.URL + "/fuqing",
+		TokenSecret:  fuqing.TokenSecret,
+		QueryBaseUrl: server.URL,
+		PushBaseUrl:  server.URL,
+		Logger:       fuqing.Logger,
+	})
+	return client, server
 }
 
 func TestFuqing_Query(t *testing.T) {
-	res, err := fuqing.Query(&QueryReq{
-		No: "JD0076810060555",
+	client, server := newFake(t, nil)
+	server.SetShipment(shipment)
+
+	res, err := client.Query(context.Background(), &expressTrace.QueryReq{
+		OrderId: 123456,
+		Number:  shipment.Number,
 	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	t.Log("res", res)
+	if res.OrderId != 123456 || res.Status != expressTrace.StatusDelivered || res.Signed != 1 || res.CompanyCode != "jd" {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if len(res.Traces) != 4 || res.Courier != "薛兵" || res.TakeTime != 18*time.Hour+56*time.Minute+43*time.Second {
+		t.Fatalf("traces %d, courier %s, takeTime %s", len(res.Traces), res.Courier, res.TakeTime)
+	}
+
+	for code, msg := range map[string]string{
+		expressTracetest.CodeWrongNumber: "[3014] 快递单号错误",
+		expressTracetest.CodeNoCompany:   "[3014] 快递公司不存在",
+		expressTracetest.CodeNoInfo:      "[3014] 没有信息",
+		expressTracetest.CodeLimited:     "[3014] 该单号被限制，错误单号",
+	} {
+		server.SetError(shipment.Number, code)
+		if _, err := client.Query(context.Background(), &expressTrace.QueryReq{Number: shipment.Number}); err == nil || err.Error() != msg {
+			t.Fatalf("%s: unexpected error %v", code, err)
+		}
+	}
+
+	server.AppCode = "other"
+	if _, err := client.Query(context.Background(), &expressTrace.QueryReq{Number: shipment.Number}); err == nil {
+		t.Fatal("expected error with invalid appCode")
+	}
 }
 
 func TestFuqing_Subscribe(t *testing.T) {
-	err := fuqing.Subscribe(&expressTrace.SubscribeReq{
+	var received *expressTrace.SubscribeRes
+	client, server := newFake(t, func(ctx context.Context, res *expressTrace.SubscribeRes) error {
+		received = res
+		return nil
+	})
+	server.SetShipment(shipment)
+
+	req := &expressTrace.SubscribeReq{
+		OrderId: 123456,
+		Number:  shipment.Number,
+	}
+	err := client.Subscribe(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Company != "jd" {
+		t.Fatalf("detected company %q", req.Company)
+	}
+	if u, ok := server.Subscribed(shipment.Number); !ok || !strings.Contains(u, "token="+client.Token(123456, shipment.Number)) {
+		t.Fatalf("unexpected url %s", u)
+	}
+	if err := server.Callback(context.Background(), shipment.Number); err != nil {
+		t.Fatal(err)
+	}
+	if received == nil || received.OrderId != 123456 || received.Status != expressTrace.StatusDelivered || len(received.Traces) != 4 {
+		t.Fatalf("unexpected result %+v", received)
+	}
+
+	server.SetError("JD0076810060555", expressTracetest.CodeLimited)
+	err = client.Subscribe(context.Background(), &expressTrace.SubscribeReq{
+		OrderId: 123457,
+		Number:  "JD0076810060555",
+	})
+	if err == nil || err.Error() != "[3014] 该单号被限制，错误单号" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestFuqing_Company(t *testing.T) {
+	client, _ := newFake(t, nil)
+	result, err := client.Company(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) == 0 || !result[0].Push {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestFuqing_Conformance(t *testing.T) {
+	client, server := newFake(t, nil)
+	expressTracetest.RunConformance(t, &expressTracetest.Conformance{
+		Provider:  client,
+		Server:    server,
+		SignField: "token",
+		States:    []string{StateAccepted, StateInTransit, StateDelivered, StateQuestion, StateException, StateReturned},
+		Status:    Status,
+	})
+}
+
+// newRecorded 返回回放testdata中录制结果的客户端，删除golden文件并填入真实的AppCode后运行测试即可重新录制
+func newRecorded(path string) *Fuqing {
+	return New(&Fuqing{
+		AppKey:       fuqing.AppKey,
+		AppSecret:    fuqing.AppSecret,
+		AppCode:      fuqing.AppCode,
+		SubscribeUrl: fuqing.SubscribeUrl,
+		TokenSecret:  fuqing.TokenSecret,
+		HttpClient:   expressTracetest.NewRecorder(path).Client(),
+		Logger:       fuqing.Logger,
+	})
+}
+
+func TestFuqing_QueryRecorded(t *testing.T) {
+	client := newRecorded("testdata/query.json")
+	res, err := client.Query(context.Background(), &expressTrace.QueryReq{
+		OrderId: 123456,
+		Number:  "JD0076810060555",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.OrderId != 123456 || res.Status != expressTrace.StatusDelivered || res.State != "3" || res.Signed != 1 || res.CompanyCode != "jd" {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if len(res.Traces) != 7 || res.LastTraceInfo != res.Traces[0].Info || res.TakeTime != 12*time.Hour+5*time.Minute {
+		t.Fatalf("traces %d, takeTime %s", len(res.Traces), res.TakeTime)
+	}
+	if res.Courier != "薛兵" || res.CourierPhone != "18740476340" {
+		t.Fatalf("courier %s %s", res.Courier, res.CourierPhone)
+	}
+
+	_, err = client.Query(context.Background(), &expressTrace.QueryReq{
+		OrderId: 123456,
+		Number:  "JD0076810099999",
+	})
+	if err == nil || err.Error() != "[3014] 没有信息" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestFuqing_SubscribeRecorded(t *testing.T) {
+	client := newRecorded("testdata/subscribe.json")
+	err := client.Subscribe(context.Background(), &expressTrace.SubscribeReq{
 		OrderId: 123456,
 		Number:  "JD0076810087472",
 	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	t.Log("succeed")
+	err = client.Subscribe(context.Background(), &expressTrace.SubscribeReq{
+		OrderId: 123457,
+		Number:  "JD0076810087472",
+	})
+	if err == nil || err.Error() != "[3014] 错误单号重复" {
+		t.Fatalf("unexpected error %v", err)
+	}
 }
 
-func TestFuqing_Company(t *testing.T) {
-	result, err := fuqing.Company()
+func TestFuqing_CompanyCache(t *testing.T) {
+	var calls = 0
+	var body = `{"status":true,"message":"","result":[{"type":"JD","name":"京东物流","logo":"https://img3.fegine.com/express/jd.jpg"}]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	client := New(&Fuqing{
+		AppKey:       fuqing.AppKey,
+		AppSecret:    fuqing.AppSecret,
+		AppCode:      fuqing.AppCode,
+		SubscribeUrl: fuqing.SubscribeUrl,
+		TokenSecret:  fuqing.TokenSecret,
+		PushBaseUrl:  server.URL,
+		Logger:       fuqing.Logger,
+	})
+	for i := 0; i < 2; i++ {
+		list, err := client.Company(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 || list[0].Type != "JD" || !list[0].Push {
+			t.Fatalf("unexpected list %+v", list)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected cached result, calls %d", calls)
+	}
+
+	body = `{"status":false,"message":"APPCODE无效"}`
+	if _, err := client.RefreshCompany(context.Background()); err == nil || err.Error() != "[3014] APPCODE无效" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+const callbackData = `{"code":"OK","no":"JD0076810087472","type":"JD","list":[{"content":"您的快件已由快递驿站代收，感谢您使用京东物流，期待再次为您服务","time":"2022-06-30 10:34:52"},{"content":"您的快件正在派送中，请您准备签收（快递员：薛兵，联系电话：18740476340）。给您服务的快递员已完成新冠疫苗接种，祝您身体健康。疫情期间，为保证安全，京东快递每日对网点消毒，快递员佩戴口罩，请您安心！","time":"2022-06-30 08:06:02"},{"content":"您的快件已到达【西安兴善营业部】","time":"2022-06-30 07:18:05"},{"content":"您的快件在【西安兴善营业部】收货完成","time":"2022-06-30 07:18:04"},{"content":"您的快件已发车","time":"2022-06-29 22:30:20"},{"content":"您的快件由【西安灞桥分拣中心】准备发往【西安兴善营业部】","time":"2022-06-29 18:01:46"},{"content":"您的快件在【西安灞桥分拣中心】分拣完成","time":"2022-06-29 15:38:48"},{"content":"您的快件已到达【西安灞桥分拣中心】","time":"2022-06-29 15:38:09"}],"state":"3","name":"京东物流","site":"www.jdwl.com","phone":"400-603-3600","logo":"https:\/\/img3.fegine.com\/express\/jd.jpg","courier":"","courierPhone":"","updateTime":"2022-06-30 10:34:52","takeTime":"0天18小时56分"}`
+
+func TestFuqing_CallbackHandler(t *testing.T) {
+	var received *expressTrace.SubscribeRes
+	handler := fuqing.CallbackHandler(func(ctx context.Context, res *expressTrace.SubscribeRes) error {
+		received = res
+		return nil
+	})
+
+	form := url.Values{"data": {callbackData}}
+	r := httptest.NewRequest(http.MethodPost, "/fuqing?orderId=33334&token="+fuqing.Token(33334, "JD0076810087472"), strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK || w.Body.String() != CallbackSuccess {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if received == nil || received.OrderId != 33334 || received.Status != expressTrace.StatusDelivered || received.CompanyCode != "jd" {
+		t.Fatalf("unexpected result %+v", received)
+	}
+	if received.TakeTime != 18*time.Hour+56*time.Minute || received.Courier != "薛兵" || received.UpdateTime == nil {
+		t.Fatalf("takeTime %s, courier %s, updateTime %v", received.TakeTime, received.Courier, received.UpdateTime)
+	}
+	if detail := received.Traces[1].Detail; detail == nil || detail.Courier != "薛兵" || detail.CourierPhone != "18740476340" {
+		t.Fatalf("unexpected detail %+v", detail)
+	}
+	if location := received.Traces[2].Location; location == nil || location.Facility != "西安兴善营业部" {
+		t.Fatalf("unexpected location %+v", location)
+	}
+
+	for _, target := range []string{
+		"/fuqing",
+		"/fuqing?orderId=33334",
+		"/fuqing?orderId=33335&token=" + fuqing.Token(33334, "JD0076810087472"),
+	} {
+		r = httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest || w.Body.String() != CallbackFail {
+			t.Fatalf("%s: status = %d, body = %s", target, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestFuqing_CallbackToken(t *testing.T) {
+	ctx := context.Background()
+	newClient := func(secret string, allowNoToken bool) *Fuqing {
+		return New(&Fuqing{
+			AppKey:       fuqing.AppKey,
+			AppSecret:    fuqing.AppSecret,
+			AppCode:      fuqing.AppCode,
+			SubscribeUrl: fuqing.SubscribeUrl,
+			TokenSecret:  secret,
+			AllowNoToken: allowNoToken,
+			Logger:       fuqing.Logger,
+		})
+	}
+	requireCode := func(name string, err error, code string) {
+		t.Helper()
+		if e, ok := err.(*baseError.Error); !ok || e.Code != code {
+			t.Errorf("%s: expected %s, got %v", name, code, err)
+		}
+	}
+	noToken := map[string]string{"data": callbackData}
+	badToken := map[string]string{"data": callbackData, "token": "invalid"}
+
+	//强制验证：不带token的推送被拒绝
+	strict := newClient(fuqing.TokenSecret, false)
+	_, err := strict.SubscribeCallback(ctx, 33334, noToken)
+	requireCode("strict", err, "3015")
+
+	//过渡模式：升级前订阅的推送地址不带token时放行，错误的token仍被拒绝
+	transitional := newClient(fuqing.TokenSecret, true)
+	if _, err := transitional.SubscribeCallback(ctx, 33334, noToken); err != nil {
+		t.Errorf("transitional: %v", err)
+	}
+	_, err = transitional.SubscribeCallback(ctx, 33334, badToken)
+	requireCode("transitional", err, "3016")
+
+	//未设置TokenSecret时不验证，订阅地址不带token
+	disabled := newClient("", false)
+	if _, err := disabled.SubscribeCallback(ctx, 33334, badToken); err != nil {
+		t.Errorf("disabled: %v", err)
+	}
+
+	//推送中的单号大小写、空白与订阅时不同
+	if strict.Token(33334, "jd0076810087472 ") != strict.Token(33334, "JD0076810087472") {
+		t.Error("token not normalized")
+	}
+	data := strings.Replace(callbackData, `"no":"JD0076810087472"`, `"no":"jd 0076810087472"`, 1)
+	if _, err := strict.SubscribeCallback(ctx, 33334, map[string]string{"data": data, "token": strict.Token(33334, "JD0076810087472")}); err != nil {
+		t.Errorf("normalized number: %v", err)
+	}
+}
+
+func TestFuqing_CallbackQuirks(t *testing.T) {
+	var cases = map[string]func(*expressTrace.SubscribeRes) bool{
+		`{"code":"OK","no":"JD0076810087472","type":"JD","state":3,"list":null}`: func(res *expressTrace.SubscribeRes) bool {
+			return res.Status == expressTrace.StatusDelivered && res.Signed == 1 && res.Traces != nil && len(res.Traces) == 0
+		},
+		`{"code":200,"no":"JD0076810087472","state":null,"list":[null,{"time":"2022-06-30 10:34:52","content":"您的快件已发车"}]}`: func(res *expressTrace.SubscribeRes) bool {
+			return res.Status == expressTrace.StatusUnknown && len(res.Traces) == 1 && res.LastTraceInfo == "您的快件已发车"
+		},
+	}
+	for data, check := range cases {
+		res, err := fuqing.SubscribeCallback(context.Background(), 33334, map[string]string{
+			"data":  data,
+			"token": fuqing.Token(33334, "JD0076810087472"),
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", data, err)
+		}
+		if !check(res) {
+			t.Fatalf("%s: unexpected result %+v", data, res)
+		}
+	}
+
+	_, err := fuqing.SubscribeCallback(context.Background(), 33334, map[string]string{"data": "{", "token": "x"})
+	if e, ok := err.(*baseError.Error); !ok || e.Code != "3013" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+// TestFuqing_UncataloguedCompany 目录未收录的快递公司与快递100填充相同的字段
+func TestFuqing_UncataloguedCompany(t *testing.T) {
+	data := `{"code":"OK","no":"ZJS001234567890","type":"ZJS","state":"2","name":"宅急送","site":"www.zjs.com.cn","phone":"400-6789-000","logo":"https://img.example.com/zjs.png","list":[]}`
+	res, err := fuqing.SubscribeCallback(context.Background(), 33334, map[string]string{
+		"data":  data,
+		"token": fuqing.Token(33334, "ZJS001234567890"),
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	t.Log("succeed", result)
+	if res.CompanyCode != "zjs" || res.CompanyName != "宅急送" || res.CompanyLogo != "https://img.example.com/zjs.png" ||
+		res.CompanySite != "" || res.CompanyPhone != "" || res.Provider != expressTrace.ProviderFuqing {
+		t.Fatalf("unexpected company %+v", res)
+	}
+}
+
+func FuzzSubscribeCallback(f *testing.F) {
+	f.Add(callbackData)
+	f.Add(`{"no":"JD0076810087472","state":3,"list":null}`)
+	f.Add(`{"no":"JD0076810087472","state":"6","list":[null],"updateTime":null,"takeTime":"天小时"}`)
+	f.Fuzz(func(t *testing.T, data string) {
+		//签名随运单号变化，先取出单号以便覆盖签名之后的解析逻辑
+		var v struct {
+			No string `json:"no"`
+		}
+		json.Unmarshal([]byte(data), &v)
+		res, err := fuqing.SubscribeCallback(context.Background(), 33334, map[string]string{
+			"data":  data,
+			"token": fuqing.Token(33334, v.No),
+		})
+		if err != nil {
+			if _, ok := err.(*baseError.Error); !ok {
+				t.Fatalf("unwrapped error %T %v", err, err)
+			}
+			return
+		}
+		if res.OrderId != 33334 || res.Traces == nil || !res.Status.Valid() {
+			t.Fatalf("unexpected result %+v", res)
+		}
+	})
 }
 
-//curl --location --request POST 'http://192.168.100.100:7031/fuqing?orderId=33334' --header 'Content-Type: application/x-www-form-urlencoded' --data-urlencode 'data={"code":"OK","no":"JD0076810087472","type":"JD","list":[{"content":"您的快件已由快递驿站代收，感谢您使用京东物流，期待再次为您服务","time":"2022-06-30 10:34:52"},{"content":"您的快件正在派送中，请您准备签收（快递员：薛兵，联系电话：18740476340）。给您服务的快递员已完成新冠疫苗接种，祝您身体健康。疫情期间，为保证安全，京东快递每日对网点消毒，快递员佩戴口罩，请您安心！","time":"2022-06-30 08:06:02"},{"content":"您的快件已到达【西安兴善营业部】","time":"2022-06-30 07:18:05"},{"content":"您的快件在【西安兴善营业部】收货完成","time":"2022-06-30 07:18:04"},{"content":"您的快件已发车","time":"2022-06-29 22:30:20"},{"content":"您的快件由【西安灞桥分拣中心】准备发往【西安兴善营业部】","time":"2022-06-29 18:01:46"},{"content":"您的快件在【西安灞桥分拣中心】分拣完成","time":"2022-06-29 15:38:48"},{"content":"您的快件已到达【西安灞桥分拣中心】","time":"2022-06-29 15:38:09"}],"state":"3","name":"京东物流","site":"www.jdwl.com","phone":"400-603-3600","logo":"https:\/\/img3.fegine.com\/express\/jd.jpg","courier":"","courierPhone":"","updateTime":"2022-06-30 10:34:52","takeTime":"0天18小时56分"}'
+//curl --location --request POST 'http://192.168.100.100:7031/fuqing?orderId=33334&token=5ea915ad234db9589f1683a8113b2bc7a7737827f2e6a76c609460a246d22ee5' --header 'Content-Type: application/x-www-form-urlencoded' --data-urlencode 'data={"code":"OK","no":"JD0076810087472","type":"JD","list":[{"content":"您的快件已由快递驿站代收，感谢您使用京东物流，期待再次为您服务","time":"2022-06-30 10:34:52"},{"content":"您的快件正在派送中，请您准备签收（快递员：薛兵，联系电话：18740476340）。给您服务的快递员已完成新冠疫苗接种，祝您身体健康。疫情期间，为保证安全，京东快递每日对网点消毒，快递员佩戴口罩，请您安心！","time":"2022-06-30 08:06:02"},{"content":"您的快件已到达【西安兴善营业部】","time":"2022-06-30 07:18:05"},{"content":"您的快件在【西安兴善营业部】收货完成","time":"2022-06-30 07:18:04"},{"content":"您的快件已发车","time":"2022-06-29 22:30:20"},{"content":"您的快件由【西安灞桥分拣中心】准备发往【西安兴善营业部】","time":"2022-06-29 18:01:46"},{"content":"您的快件在【西安灞桥分拣中心】分拣完成","time":"2022-06-29 15:38:48"},{"content":"您的快件已到达【西安灞桥分拣中心】","time":"2022-06-29 15:38:09"}],"state":"3","name":"京东物流","site":"www.jdwl.com","phone":"400-603-3600","logo":"https:\/\/img3.fegine.com\/express\/jd.jpg","courier":"","courierPhone":"","updateTime":"2022-06-30 10:34:52","takeTime":"0天18小时56分"}'
@@ -0,0 +1,48 @@
+package fuqing
+
+import (
+	baseError "github.com/go-tron/base-error"
+	expressTrace "github.com/go-tron/express-trace"
+	"net/http"
+)
+
+const (
+	CallbackSuccess = "success"
+	CallbackFail    = "fail"
+)
+
+// CallbackHandler 处理推送请求，成功返回 CallbackSuccess，失败时返回非200状态码以便服务商重推
+func (c *Fuqing) CallbackHandler(fn expressTrace.CallbackFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orderId, data, err := expressTrace.ParseCallback(r)
+		if err != nil {
+			c.reply(w, http.StatusBadRequest, ErrorCallbackParams("data"))
+			return
+		}
+		res, err := c.SubscribeCallback(r.Context(), orderId, data)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if e, ok := err.(*baseError.Error); ok && !e.System {
+				status = http.StatusBadRequest
+			}
+			c.reply(w, status, err)
+			return
+		}
+		if err := fn(r.Context(), res); err != nil {
+			c.reply(w, http.StatusInternalServerError, err)
+			return
+		}
+		c.reply(w, http.StatusOK, nil)
+	})
+}
+
+func (c *Fuqing) reply(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	if err != nil {
+		c.Logger.Error("推送处理失败", c.Logger.Field("error", err))
+		w.Write([]byte(CallbackFail))
+		return
+	}
+	w.Write([]byte(CallbackSuccess))
+}
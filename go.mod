@@ -3,10 +3,13 @@ module github.com/go-tron/express-trace
 go 1.19
 
 require (
+	github.com/go-playground/validator/v10 v10.19.0
+	github.com/go-resty/resty/v2 v2.12.0
 	github.com/go-tron/base-error v1.0.2
 	github.com/go-tron/config v1.0.1
 	github.com/go-tron/local-time v1.0.0
 	github.com/go-tron/logger v1.0.1
+	github.com/spf13/viper v1.16.0
 )
 
 require (
@@ -14,9 +17,6 @@ require (
 	github.com/gabriel-vasile/mimetype v1.4.3 // indirect
 	github.com/go-playground/locales v0.14.1 // indirect
 	github.com/go-playground/universal-translator v0.18.1 // indirect
-	github.com/go-playground/validator/v10 v10.19.0 // indirect
-	github.com/go-resty/resty/v2 v2.12.0 // indirect
-	github.com/go-tron/random v1.0.0 // indirect
 	github.com/hashicorp/hcl v1.0.0 // indirect
 	github.com/leodido/go-urn v1.4.0 // indirect
 	github.com/magiconair/properties v1.8.7 // indirect
@@ -27,7 +27,6 @@ require (
 	github.com/spf13/cast v1.5.1 // indirect
 	github.com/spf13/jwalterweatherman v1.1.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
-	github.com/spf13/viper v1.16.0 // indirect
 	github.com/subosito/gotenv v1.4.2 // indirect
 	go.uber.org/multierr v1.10.0 // indirect
 	go.uber.org/zap v1.25.0 // indirect
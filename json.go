@@ -0,0 +1,158 @@
+package expressTrace
+
+import (
+	"bytes"
+	"encoding/json"
+	localTime "github.com/go-tron/local-time"
+	"time"
+)
+
+// LooseString 服务商同一字段可能返回字符串、数字或布尔值(如 ischeck、state)，统一按字符串解析，null 视为空字符串
+type LooseString string
+
+func (s *LooseString) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case len(data) > 0 && data[0] == '"':
+		var v string
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		*s = LooseString(v)
+	case bytes.Equal(data, []byte("null")):
+		*s = ""
+	case bytes.Equal(data, []byte("true")):
+		*s = "1"
+	case bytes.Equal(data, []byte("false")):
+		*s = "0"
+	default:
+		var v json.Number
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		*s = LooseString(v)
+	}
+	return nil
+}
+
+func (s LooseString) String() string {
+	return string(s)
+}
+
+// zonedTime 按RFC3339带时区偏移序列化 localTime.Time，仅用于 FileStore。
+// localTime.Time 序列化时不带时区且按 time.Local 解析，保存后重新加载时 Normalize 设置的时区会丢失
+type zonedTime localTime.Time
+
+func zoned(t *localTime.Time) *zonedTime {
+	return (*zonedTime)(t)
+}
+
+func (t zonedTime) MarshalJSON() ([]byte, error) {
+	if time.Time(t).IsZero() {
+		return []byte(`""`), nil
+	}
+	return []byte(time.Time(t).Format(`"` + time.RFC3339 + `"`)), nil
+}
+
+// UnmarshalJSON 兼容旧版本保存的不带时区的 localTime.Layout 格式，按 DefaultTimeZone 解析
+func (t *zonedTime) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s == "" {
+		return nil
+	}
+	v, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		if v, err = time.ParseInLocation(localTime.Layout, s, DefaultTimeZone); err != nil {
+			return err
+		}
+	}
+	*t = zonedTime(v)
+	return nil
+}
+
+// storedShipment FileStore 保存运单使用的格式，时间带时区偏移并保存 Notified，对外(webhook等)仍使用 SubscribeRes 的JSON
+type storedShipment struct {
+	*SubscribeRes
+}
+
+func (r storedShipment) MarshalJSON() ([]byte, error) {
+	type alias SubscribeRes
+	var traces = make([]storedTrace, 0, len(r.Traces))
+	for i := range r.Traces {
+		traces = append(traces, storedTrace{&r.Traces[i]})
+	}
+	return json.Marshal(&struct {
+		*alias
+		LastTraceTime *zonedTime    `json:"lastTraceTime"`
+		UpdateTime    *zonedTime    `json:"updateTime,omitempty"`
+		Traces        []storedTrace `json:"traces"`
+		Notified      int64         `json:"notified,omitempty"`
+	}{
+		alias:         (*alias)(r.SubscribeRes),
+		LastTraceTime: zoned(r.LastTraceTime),
+		UpdateTime:    zoned(r.UpdateTime),
+		Traces:        traces,
+		Notified:      r.Notified,
+	})
+}
+
+func (r *storedShipment) UnmarshalJSON(data []byte) error {
+	type alias SubscribeRes
+	r.SubscribeRes = &SubscribeRes{}
+	v := struct {
+		*alias
+		LastTraceTime *zonedTime    `json:"lastTraceTime"`
+		UpdateTime    *zonedTime    `json:"updateTime,omitempty"`
+		Traces        []storedTrace `json:"traces"`
+		Notified      int64         `json:"notified,omitempty"`
+	}{
+		alias: (*alias)(r.SubscribeRes),
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	r.Notified = v.Notified
+	r.LastTraceTime = (*localTime.Time)(v.LastTraceTime)
+	r.UpdateTime = (*localTime.Time)(v.UpdateTime)
+	if v.Traces != nil {
+		r.Traces = make([]Trace, 0, len(v.Traces))
+		for _, t := range v.Traces {
+			r.Traces = append(r.Traces, *t.Trace)
+		}
+	}
+	return nil
+}
+
+type storedTrace struct {
+	*Trace
+}
+
+func (t storedTrace) MarshalJSON() ([]byte, error) {
+	type alias Trace
+	return json.Marshal(&struct {
+		*alias
+		Time *zonedTime `json:"time"`
+	}{
+		alias: (*alias)(t.Trace),
+		Time:  zoned(t.Time),
+	})
+}
+
+func (t *storedTrace) UnmarshalJSON(data []byte) error {
+	type alias Trace
+	t.Trace = &Trace{}
+	v := struct {
+		*alias
+		Time *zonedTime `json:"time"`
+	}{
+		alias: (*alias)(t.Trace),
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	t.Time = (*localTime.Time)(v.Time)
+	return nil
+}
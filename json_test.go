@@ -0,0 +1,33 @@
+package expressTrace
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestLooseString(t *testing.T) {
+	var cases = map[string]LooseString{
+		`"1"`:   "1",
+		`1`:     "1",
+		`304`:   "304",
+		`null`:  "",
+		`true`:  "1",
+		`false`: "0",
+	}
+	for data, want := range cases {
+		var v struct {
+			S LooseString `json:"s"`
+		}
+		if err := json.Unmarshal([]byte(`{"s":`+data+`}`), &v); err != nil {
+			t.Fatalf("%s: %v", data, err)
+		}
+		if v.S != want {
+			t.Errorf("%s: got %q, want %q", data, v.S, want)
+		}
+	}
+
+	var v LooseString
+	if err := json.Unmarshal([]byte(`{}`), &v); err == nil {
+		t.Fatal("expected error for object")
+	}
+}
@@ -0,0 +1,47 @@
+package kuaidi100
+
+import (
+	"encoding/json"
+	expressTrace "github.com/go-tron/express-trace"
+	"net/http"
+)
+
+// CallbackHandler 处理推送请求，按快递100要求返回 Response，returnCode非200时快递100会重推
+func (c *Kuaidi100) CallbackHandler(fn expressTrace.CallbackFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orderId, data, err := expressTrace.ParseCallback(r)
+		if err != nil {
+			c.reply(w, ErrorCallbackParams("param"))
+			return
+		}
+		res, err := c.SubscribeCallback(r.Context(), orderId, data)
+		if err != nil {
+			c.reply(w, err)
+			return
+		}
+		if err := fn(r.Context(), res); err != nil {
+			c.reply(w, err)
+			return
+		}
+		c.reply(w, nil)
+	})
+}
+
+func (c *Kuaidi100) reply(w http.ResponseWriter, err error) {
+	var res = Response{
+		Result:     true,
+		ReturnCode: "200",
+		Message:    "成功",
+	}
+	if err != nil {
+		c.Logger.Error("推送处理失败", c.Logger.Field("error", err))
+		res = Response{
+			Result:     false,
+			ReturnCode: "500",
+			Message:    err.Error(),
+		}
+	}
+	body, _ := json.Marshal(res)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(body)
+}
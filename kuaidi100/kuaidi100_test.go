@@ -1,21 +1,152 @@
 package kuaidi100
 
 import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	baseError "github.com/go-tron/base-error"
 	expressTrace "github.com/go-tron/express-trace"
+	"github.com/go-tron/express-trace/expressTracetest"
 	"github.com/go-tron/logger"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 )
 
 var kuaidi100 = New(&Kuaidi100{
-	key:          "BoQtnsPM7007",
-	Customer:     "994F35FF7ECA32CE736F02BE3C0545CE",
+	key:          "test-key",
+	Customer:     "test-customer",
 	SubscribeUrl: "http://express.eioos.com/kuaidi100",
 	SignSalt:     "123",
 	Logger:       logger.NewZap("kuaidi100", "info"),
 })
 
+var shipment = &expressTracetest.Shipment{
+	Number:  "JD0076810060555",
+	Company: "jd",
+	Status:  expressTrace.StatusDelivered,
+	Traces: []expressTracetest.Trace{
+		{Time: "2022-06-30 10:34:33", Info: "您的快件已由快递驿站代收，感谢您使用京东物流，期待再次为您服务", StatusCode: "304", StatusName: "投柜或站签收"},
+		{Time: "2022-06-30 08:27:50", Info: "您的快件正在派送中，请您准备签收（快递员：薛兵，联系电话：18740476340）。", StatusCode: "0", StatusName: "在途"},
+		{Time: "2022-06-29 22:28:45", Info: "您的快件在【西安灞桥分拣中心】分拣完成", StatusCode: "1002", StatusName: "干线"},
+	},
+}
+
+// newFake 返回连接模拟服务器的客户端，推送地址指向由fn处理的本地CallbackHandler
+func newFake(t *testing.T, fn expressTrace.CallbackFunc) (*Kuaidi100, *expressTracetest.Kuaidi100Server) {
+	server := expressTracetest.NewKuaidi100Server(kuaidi100.key, kuaidi100.Customer)
+	t.Cleanup(server.Close)
+
+	var client *Kuaidi100
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client.CallbackHandler(fn).ServeHTTP(w, r)
+	}))
+	t.Cleanup(callback.Close)
+
+	client = New(&Kuaidi100{
+		key:          kuaidi100.key,
+		Customer:     kuaidi100.Customer,
+		SubscribeUrl: callback.URL + "/kuaidi100",
+		SignSalt:     kuaidi100.SignSalt,
+		BaseUrl:      server.URL,
+		Logger:       kuaidi100.Logger,
+	})
+	return client, server
+}
+
 func TestKuaidi100_Subscribe(t *testing.T) {
-	err := kuaidi100.Subscribe(&expressTrace.SubscribeReq{
+	var received *expressTrace.SubscribeRes
+	client, server := newFake(t, func(ctx context.Context, res *expressTrace.SubscribeRes) error {
+		received = res
+		return nil
+	})
+	server.SetShipment(shipment)
+
+	err := client.Subscribe(context.Background(), &expressTrace.SubscribeReq{
+		OrderId: 123456,
+		Number:  shipment.Number,
+		Company: "JD",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Callback(context.Background(), shipment.Number); err != nil {
+		t.Fatal(err)
+	}
+	if received == nil || received.OrderId != 123456 || received.Status != expressTrace.StatusDelivered || received.Subscription != expressTrace.SubscriptionShutdown {
+		t.Fatalf("unexpected result %+v", received)
+	}
+	if len(received.Traces) != 3 || received.Traces[0].State != "304" || received.CourierPhone != "18740476340" {
+		t.Fatalf("unexpected traces %+v", received.Traces)
+	}
+
+	server.SetError(shipment.Number, expressTracetest.CodeWrongNumber)
+	err = client.Subscribe(context.Background(), &expressTrace.SubscribeReq{
+		OrderId: 123456,
+		Number:  shipment.Number,
+		Company: "JD",
+	})
+	if err == nil || err.Error() != "[3014] 快递单号错误" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestKuaidi100_Query(t *testing.T) {
+	client, server := newFake(t, nil)
+	server.SetShipment(shipment)
+
+	res, err := client.Query(context.Background(), &expressTrace.QueryReq{
+		OrderId: 123456,
+		Number:  shipment.Number,
+		Company: "jd",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.OrderId != 123456 || res.Status != expressTrace.StatusDelivered || res.Signed != 1 || res.CompanyName != "京东物流" {
+		t.Fatalf("unexpected result %+v", res)
+	}
+
+	for code, msg := range map[string]string{
+		expressTracetest.CodeWrongNumber: "[3014] 快递单号错误",
+		expressTracetest.CodeNoCompany:   "[3014] 快递公司不存在",
+		expressTracetest.CodeNoInfo:      "[3014] 查询无结果，请隔段时间再查",
+		expressTracetest.CodeLimited:     "[3014] 该单号被限制，错误单号",
+	} {
+		server.SetError(shipment.Number, code)
+		if _, err := client.Query(context.Background(), &expressTrace.QueryReq{Number: shipment.Number}); err == nil || err.Error() != msg {
+			t.Fatalf("%s: unexpected error %v", code, err)
+		}
+	}
+}
+
+func TestKuaidi100_Conformance(t *testing.T) {
+	client, server := newFake(t, nil)
+	expressTracetest.RunConformance(t, &expressTracetest.Conformance{
+		Provider:  client,
+		Server:    server,
+		SignField: "sign",
+		States: []string{StateInTransit, StateAccepted, StateException, StateDelivered, StateCanceled,
+			StateInProgress, StateReturned, StateTransfer, StateClearance, StateRefused},
+		Status: Status,
+	})
+}
+
+func TestKuaidi100_SubscribeRecorded(t *testing.T) {
+	//删除golden文件并填入真实的key与customer后运行测试即可重新录制
+	client := New(&Kuaidi100{
+		key:          kuaidi100.key,
+		Customer:     kuaidi100.Customer,
+		SubscribeUrl: kuaidi100.SubscribeUrl,
+		SignSalt:     kuaidi100.SignSalt,
+		HttpClient:   expressTracetest.NewRecorder("testdata/subscribe.json").Client(),
+		Logger:       kuaidi100.Logger,
+	})
+	err := client.Subscribe(context.Background(), &expressTrace.SubscribeReq{
 		OrderId: 123456,
 		Number:  "JD0076810060555",
 		Company: "JD",
@@ -23,7 +154,254 @@ func TestKuaidi100_Subscribe(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	t.Log("succeed")
+	err = client.Subscribe(context.Background(), &expressTrace.SubscribeReq{
+		OrderId: 123457,
+		Number:  "JD0076810060555",
+		Company: "JD",
+	})
+	if err == nil || err.Error() != "[3014] 重复订阅" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestKuaidi100_QueryBaseUrl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/poll/query.do" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		if r.FormValue("sign") != kuaidi100.querySign(r.FormValue("param")) {
+			t.Errorf("sign mismatch")
+		}
+		w.Write([]byte(`{"message":"ok","nu":"JD0076810060555","ischeck":"1","com":"jd","status":"200","state":"3","data":[{"time":"2022-06-30 10:34:33","context":"您的快件已由快递驿站代收"}]}`))
+	}))
+	defer server.Close()
+
+	client := New(&Kuaidi100{
+		key:          kuaidi100.key,
+		Customer:     kuaidi100.Customer,
+		SubscribeUrl: kuaidi100.SubscribeUrl,
+		SignSalt:     kuaidi100.SignSalt,
+		BaseUrl:      server.URL,
+		HttpClient:   server.Client(),
+		Logger:       kuaidi100.Logger,
+	})
+	res, err := client.Query(context.Background(), &expressTrace.QueryReq{
+		OrderId: 123456,
+		Number:  "JD0076810060555",
+		Company: "jd",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.OrderId != 123456 || res.Status != expressTrace.StatusDelivered || res.Signed != 1 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+}
+
+const callbackParam = `{"status":"shutdown","billstatus":"check","message":"","lastResult":{"message":"ok","nu":"JD0076810060555","ischeck":"1","com":"jd","status":"200","data":[{"time":"2022-06-30 10:34:33","context":"您的快件已由快递驿站代收，感谢您使用京东物流，期待再次为您服务","ftime":"2022-06-30 10:34:33","areaCode":null,"areaName":null,"status":"投柜或站签收","location":"","areaCenter":null,"areaPinYin":null,"statusCode":"304"},{"time":"2022-06-30 08:27:50","context":"您的快件正在派送中，请您准备签收（快递员：薛兵，联系电话：18740476340）。给您服务的快递员已完成新冠疫苗接种，祝您身体健康。疫情期间，为保证安全，京东快递每日对网点消毒，快递员佩戴口罩，请您安心！","ftime":"2022-06-30 08:27:50","areaCode":null,"areaName":null,"status":"在途","location":"","areaCenter":null,"areaPinYin":null,"statusCode":"0"},{"time":"2022-06-29 22:30:20","context":"您的快件已发车","ftime":"2022-06-29 22:30:20","areaCode":null,"areaName":null,"status":"在途","location":"","areaCenter":null,"areaPinYin":null,"statusCode":"0"},{"time":"2022-06-29 22:28:50","context":"您的快件由【西安灞桥分拣中心】准备发往【西安兴善营业部】","ftime":"2022-06-29 22:28:50","areaCode":"CN610111000000","areaName":"陕西,西安市,灞桥区","status":"干线","location":"","areaCenter":"109.064671,34.273409","areaPinYin":"ba qiao qu","statusCode":"1002"},{"time":"2022-06-29 22:28:45","context":"您的快件在【西安灞桥分拣中心】分拣完成","ftime":"2022-06-29 22:28:45","areaCode":"CN610111000000","areaName":"陕西,西安市,灞桥区","status":"干线","location":"","areaCenter":"109.064671,34.273409","areaPinYin":"ba qiao qu","statusCode":"1002"}],"state":"304","condition":"00","routeInfo":{"from":{"number":"CN610111000000","name":"陕西,西安市,灞桥区"},"cur":{"number":"CN610111000000","name":"陕西,西安市,灞桥区"},"to":{"number":"CN610111000000","name":"陕西,西安市,灞桥区"}},"isLoop":false}}`
+const callbackSign = "315EDA9CDABADA878C643EBFE3DBCF1B"
+
+func TestKuaidi100_CallbackHandler(t *testing.T) {
+	var received *expressTrace.SubscribeRes
+	handler := kuaidi100.CallbackHandler(func(ctx context.Context, res *expressTrace.SubscribeRes) error {
+		received = res
+		return nil
+	})
+
+	form := url.Values{"param": {callbackParam}, "sign": {callbackSign}}
+	r := httptest.NewRequest(http.MethodPost, "/kuaidi100?orderId=33333", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if body := w.Body.String(); body != `{"result":true,"returnCode":"200","message":"成功"}` {
+		t.Fatalf("body = %s", body)
+	}
+	if received == nil || received.OrderId != 33333 || received.Number != "JD0076810060555" || received.CompanyName != "京东物流" {
+		t.Fatalf("unexpected result %+v", received)
+	}
+	if received.Current == nil || received.Current.AreaCode != "CN610111000000" || received.Destination == nil || received.Destination.AreaName != "陕西,西安市,灞桥区" {
+		t.Fatalf("unexpected route %+v %+v", received.Current, received.Destination)
+	}
+	if received.TakeTime != 12*time.Hour+5*time.Minute+48*time.Second || received.CourierPhone != "18740476340" || received.UpdateTime == nil {
+		t.Fatalf("takeTime %s, courierPhone %s, updateTime %v", received.TakeTime, received.CourierPhone, received.UpdateTime)
+	}
+	if trace := received.Traces[0]; trace.Status != expressTrace.StatusDelivered || trace.State != "304" || trace.StateName != "投柜或站签收" {
+		t.Fatalf("unexpected trace %+v", trace)
+	}
+	if location := received.Traces[3].Location; location == nil || location.AreaCode != "CN610111000000" || location.Facility != "西安灞桥分拣中心" {
+		t.Fatalf("unexpected location %+v", location)
+	}
+
+	form.Set("sign", "invalid")
+	r = httptest.NewRequest(http.MethodPost, "/kuaidi100?orderId=33333", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), `"returnCode":"500"`) {
+		t.Fatalf("body = %s", w.Body.String())
+	}
+}
+
+func TestKuaidi100_AutoResubscribe(t *testing.T) {
+	var subscribed map[string]interface{}
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		subscribed = nil
+		json.Unmarshal([]byte(r.URL.Query().Get("param")), &subscribed)
+		w.Write([]byte(`{"result":true,"returnCode":"200","message":"提交成功"}`))
+	}))
+	defer server.Close()
+
+	client := New(&Kuaidi100{
+		key:              kuaidi100.key,
+		Customer:         kuaidi100.Customer,
+		SubscribeUrl:     kuaidi100.SubscribeUrl,
+		SignSalt:         kuaidi100.SignSalt,
+		AutoResubscribe:  true,
+		ResubscribeLimit: 2,
+		BaseUrl:          server.URL,
+		Logger:           kuaidi100.Logger,
+	})
+
+	//解析推送不会重新订阅
+	param := `{"status":"abort","billstatus":"","message":"3天查询无记录","autoCheck":"1","comOld":"yuantong","comNew":"jd","lastResult":{"message":"ok","nu":"JD0076810060555","ischeck":"0","com":"yuantong","status":"200","data":[],"state":"0"}}`
+	hash := md5.Sum([]byte(param + client.SignSalt))
+	res, err := client.SubscribeCallback(context.Background(), 33333, map[string]string{
+		"param": param,
+		"sign":  strings.ToUpper(hex.EncodeToString(hash[:])),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Subscription != expressTrace.SubscriptionAbort || res.SubscriptionMessage != "3天查询无记录" || res.CompanyCode != "jd" || calls != 0 {
+		t.Fatalf("unexpected result %+v, calls %d", res, calls)
+	}
+
+	ok, err := client.Resubscribe(context.Background(), res)
+	if err != nil || !ok {
+		t.Fatalf("resubscribe: %v %v", ok, err)
+	}
+	if subscribed["company"] != "jd" || subscribed["number"] != "JD0076810060555" {
+		t.Fatalf("unexpected resubscribe %v", subscribed)
+	}
+
+	//达到次数上限、无法恢复的中止原因或未开启时不再订阅
+	for name, modify := range map[string]func(res *expressTrace.SubscribeRes){
+		"limit":     func(res *expressTrace.SubscribeRes) { res.Resubscribes = 2 },
+		"permanent": func(res *expressTrace.SubscribeRes) { res.SubscriptionMessage = "60天无变化" },
+		"expired": func(res *expressTrace.SubscribeRes) {
+			res.SubscriptionMessage = "快递公司参数异常：单号不存在或者已经过期"
+		},
+		"delivered": func(res *expressTrace.SubscribeRes) { res.Status = expressTrace.StatusDelivered },
+		"polling":   func(res *expressTrace.SubscribeRes) { res.Subscription = expressTrace.SubscriptionPolling },
+	} {
+		calls = 0
+		v := *res
+		modify(&v)
+		if ok, err := client.Resubscribe(context.Background(), &v); ok || err != nil || calls != 0 {
+			t.Errorf("%s: resubscribed %v %v", name, ok, err)
+		}
+	}
+	client.AutoResubscribe = false
+	if ok, _ := client.Resubscribe(context.Background(), res); ok {
+		t.Error("resubscribed with AutoResubscribe disabled")
+	}
+}
+
+func TestKuaidi100_CallbackQuirks(t *testing.T) {
+	var cases = map[string]func(*expressTrace.SubscribeRes) bool{
+		`{"status":"shutdown","lastResult":{"nu":"JD0076810060555","ischeck":1,"com":"jd","state":304,"data":null}}`: func(res *expressTrace.SubscribeRes) bool {
+			return res.Signed == 1 && res.Status == expressTrace.StatusDelivered && res.State == "304" && res.Traces != nil && len(res.Traces) == 0
+		},
+		`{"status":"polling","lastResult":{"nu":"JD0076810060555","com":"jd","state":"0","data":[null,{"time":"2022-06-29 22:30:20","context":"您的快件已发车","statusCode":1002}],"routeInfo":{"from":null,"cur":"西安市","to":"北京市"}}}`: func(res *expressTrace.SubscribeRes) bool {
+			return res.Signed == 0 && len(res.Traces) == 1 && res.Current != nil && res.Current.AreaName == "西安市" &&
+				res.Destination != nil && res.Destination.AreaName == "北京市" && res.Origin == nil
+		},
+		`{"status":"polling","lastResult":{"nu":"JD0076810060555","ischeck":false,"routeInfo":{"to":{"number":110000,"name":"北京市"}}}}`: func(res *expressTrace.SubscribeRes) bool {
+			return res.Signed == 0 && res.Destination != nil && res.Destination.AreaCode == "110000"
+		},
+	}
+	for param, check := range cases {
+		res, err := kuaidi100.SubscribeCallback(context.Background(), 33333, map[string]string{
+			"param": param,
+			"sign":  callbackSignOf(param),
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", param, err)
+		}
+		if !check(res) {
+			t.Fatalf("%s: unexpected result %+v", param, res)
+		}
+	}
+
+	_, err := kuaidi100.SubscribeCallback(context.Background(), 33333, map[string]string{"param": "{", "sign": callbackSignOf("{")})
+	if e, ok := err.(*baseError.Error); !ok || e.Code != "3013" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+// TestKuaidi100_UncataloguedCompany 目录未收录的快递公司与福清填充相同的字段
+func TestKuaidi100_UncataloguedCompany(t *testing.T) {
+	param := `{"status":"polling","lastResult":{"nu":"ZJS001234567890","com":"zhaijisong","state":"0","data":[]}}`
+	res, err := kuaidi100.SubscribeCallback(context.Background(), 33333, map[string]string{
+		"param": param,
+		"sign":  callbackSignOf(param),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.CompanyCode != "zhaijisong" || res.CompanyName != "宅急送" || res.CompanyLogo != "https://cdn.kuaidi100.com/images/all/56/zhaijisong.png" ||
+		res.CompanySite != "" || res.CompanyPhone != "" || res.Provider != expressTrace.ProviderKuaidi100 {
+		t.Fatalf("unexpected company %+v", res)
+	}
+}
+
+func FuzzSubscribeCallback(f *testing.F) {
+	f.Add(callbackParam)
+	f.Add(`{"status":"abort","lastResult":{"ischeck":1,"state":304,"data":null,"routeInfo":{"to":"北京市"}}}`)
+	f.Add(`{"lastResult":null}`)
+	f.Fuzz(func(t *testing.T, param string) {
+		res, err := kuaidi100.SubscribeCallback(context.Background(), 33333, map[string]string{
+			"param": param,
+			"sign":  callbackSignOf(param),
+		})
+		if err != nil {
+			if _, ok := err.(*baseError.Error); !ok {
+				t.Fatalf("unwrapped error %T %v", err, err)
+			}
+			return
+		}
+		if res.OrderId != 33333 || res.Traces == nil || !res.Status.Valid() {
+			t.Fatalf("unexpected result %+v", res)
+		}
+	})
+}
+
+func callbackSignOf(param string) string {
+	hash := md5.Sum([]byte(param + kuaidi100.SignSalt))
+	return strings.ToUpper(hex.EncodeToString(hash[:]))
+}
+
+func TestStatus(t *testing.T) {
+	var cases = map[string]expressTrace.Status{
+		"0":    expressTrace.StatusInTransit,
+		"1002": expressTrace.StatusInTransit,
+		"101":  expressTrace.StatusAccepted,
+		"304":  expressTrace.StatusDelivered,
+		"4":    expressTrace.StatusReturned,
+		"501":  expressTrace.StatusDelivering,
+		"6":    expressTrace.StatusReturning,
+		"11":   expressTrace.StatusInTransit,
+		"14":   expressTrace.StatusRejected,
+		"99":   expressTrace.StatusUnknown,
+	}
+	for state, want := range cases {
+		if got := Status(state); got != want {
+			t.Errorf("Status(%q) = %s, want %s", state, got, want)
+		}
+	}
 }
 
 //curl --location --request POST 'http://192.168.100.100:7031/kuaidi100?orderId=33333' --header 'Content-Type: application/x-www-form-urlencoded' --data-urlencode 'param={"status":"shutdown","billstatus":"check","message":"","lastResult":{"message":"ok","nu":"JD0076810060555","ischeck":"1","com":"jd","status":"200","data":[{"time":"2022-06-30 10:34:33","context":"您的快件已由快递驿站代收，感谢您使用京东物流，期待再次为您服务","ftime":"2022-06-30 10:34:33","areaCode":null,"areaName":null,"status":"投柜或站签收","location":"","areaCenter":null,"areaPinYin":null,"statusCode":"304"},{"time":"2022-06-30 08:27:50","context":"您的快件正在派送中，请您准备签收（快递员：薛兵，联系电话：18740476340）。给您服务的快递员已完成新冠疫苗接种，祝您身体健康。疫情期间，为保证安全，京东快递每日对网点消毒，快递员佩戴口罩，请您安心！","ftime":"2022-06-30 08:27:50","areaCode":null,"areaName":null,"status":"在途","location":"","areaCenter":null,"areaPinYin":null,"statusCode":"0"},{"time":"2022-06-29 22:30:20","context":"您的快件已发车","ftime":"2022-06-29 22:30:20","areaCode":null,"areaName":null,"status":"在途","location":"","areaCenter":null,"areaPinYin":null,"statusCode":"0"},{"time":"2022-06-29 22:28:50","context":"您的快件由【西安灞桥分拣中心】准备发往【西安兴善营业部】","ftime":"2022-06-29 22:28:50","areaCode":"CN610111000000","areaName":"陕西,西安市,灞桥区","status":"干线","location":"","areaCenter":"109.064671,34.273409","areaPinYin":"ba qiao qu","statusCode":"1002"},{"time":"2022-06-29 22:28:45","context":"您的快件在【西安灞桥分拣中心】分拣完成","ftime":"2022-06-29 22:28:45","areaCode":"CN610111000000","areaName":"陕西,西安市,灞桥区","status":"干线","location":"","areaCenter":"109.064671,34.273409","areaPinYin":"ba qiao qu","statusCode":"1002"}],"state":"304","condition":"00","routeInfo":{"from":{"number":"CN610111000000","name":"陕西,西安市,灞桥区"},"cur":{"number":"CN610111000000","name":"陕西,西安市,灞桥区"},"to":{"number":"CN610111000000","name":"陕西,西安市,灞桥区"}},"isLoop":false}}' --data-urlencode 'sign=315EDA9CDABADA878C643EBFE3DBCF1B'
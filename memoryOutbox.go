@@ -0,0 +1,74 @@
+package expressTrace
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+var _ Outbox = (*MemoryOutbox)(nil)
+
+// MemoryOutbox 进程重启后未发送的webhook会丢失
+type MemoryOutbox struct {
+	mu      sync.RWMutex
+	entries map[string]*OutboxEntry
+}
+
+func NewMemoryOutbox() *MemoryOutbox {
+	return &MemoryOutbox{
+		entries: make(map[string]*OutboxEntry),
+	}
+}
+
+func (o *MemoryOutbox) Put(ctx context.Context, e *OutboxEntry) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.put(e)
+	return nil
+}
+
+func (o *MemoryOutbox) put(e *OutboxEntry) bool {
+	if old, ok := o.entries[e.key()]; ok && old.Sequence > e.Sequence {
+		return false
+	}
+	v := *e
+	o.entries[e.key()] = &v
+	return true
+}
+
+func (o *MemoryOutbox) Delete(ctx context.Context, e *OutboxEntry) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.delete(e)
+	return nil
+}
+
+func (o *MemoryOutbox) delete(e *OutboxEntry) bool {
+	if old, ok := o.entries[e.key()]; !ok || old.Sequence > e.Sequence {
+		return false
+	}
+	delete(o.entries, e.key())
+	return true
+}
+
+// List 按orderId、Url排序
+func (o *MemoryOutbox) List(ctx context.Context) ([]*OutboxEntry, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.all(), nil
+}
+
+func (o *MemoryOutbox) all() []*OutboxEntry {
+	var list = make([]*OutboxEntry, 0, len(o.entries))
+	for _, e := range o.entries {
+		v := *e
+		list = append(list, &v)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].OrderId != list[j].OrderId {
+			return list[i].OrderId < list[j].OrderId
+		}
+		return list[i].Url < list[j].Url
+	})
+	return list
+}
@@ -0,0 +1,101 @@
+package expressTrace
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+var (
+	_ Store   = (*MemoryStore)(nil)
+	_ Updater = (*MemoryStore)(nil)
+)
+
+type MemoryStore struct {
+	mu      sync.RWMutex
+	data    map[int64]*SubscribeRes
+	numbers map[string]int64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		data:    make(map[int64]*SubscribeRes),
+		numbers: make(map[string]int64),
+	}
+}
+
+func (s *MemoryStore) Save(ctx context.Context, res *SubscribeRes) error {
+	if res.OrderId == 0 {
+		return ErrorSubscription(res.Number)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(res)
+	return nil
+}
+
+func (s *MemoryStore) put(res *SubscribeRes) {
+	s.data[res.OrderId] = clone(res)
+	if res.Number != "" {
+		s.numbers[res.Number] = res.OrderId
+	}
+}
+
+func (s *MemoryStore) Load(ctx context.Context, orderId int64) (*SubscribeRes, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return clone(s.data[orderId]), nil
+}
+
+func (s *MemoryStore) LoadByNumber(ctx context.Context, number string) (*SubscribeRes, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	orderId, ok := s.numbers[number]
+	if !ok {
+		return nil, nil
+	}
+	return clone(s.data[orderId]), nil
+}
+
+// ListActive 返回未到达终态的运单，按orderId排序
+func (s *MemoryStore) ListActive(ctx context.Context) ([]*SubscribeRes, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var list = make([]*SubscribeRes, 0)
+	for _, v := range s.data {
+		if !v.Status.Terminal() {
+			list = append(list, clone(v))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].OrderId < list[j].OrderId
+	})
+	return list, nil
+}
+
+// Update 在写锁内读取、合并并保存运单
+func (s *MemoryStore) Update(ctx context.Context, orderId int64, number string, fn func(old *SubscribeRes) (*SubscribeRes, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.update(orderId, number, fn)
+}
+
+func (s *MemoryStore) update(orderId int64, number string, fn func(old *SubscribeRes) (*SubscribeRes, error)) error {
+	res, err := fn(s.find(orderId, number))
+	if err != nil {
+		return err
+	}
+	if res.OrderId == 0 {
+		return ErrorSubscription(res.Number)
+	}
+	s.put(res)
+	return nil
+}
+
+// find orderId为0时按number查找，返回副本
+func (s *MemoryStore) find(orderId int64, number string) *SubscribeRes {
+	if orderId == 0 {
+		orderId = s.numbers[number]
+	}
+	return clone(s.data[orderId])
+}
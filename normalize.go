@@ -0,0 +1,150 @@
+package expressTrace
+
+import (
+	localTime "github.com/go-tron/local-time"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// NearDuplicateWindow 文本相同且时间相差不超过该间隔的轨迹视为重复
+const NearDuplicateWindow = 2 * time.Minute
+
+// DefaultTimeZone 服务商返回的时间不带时区，默认按北京时间处理
+var DefaultTimeZone = loadTimeZone(localTime.Zone)
+
+func loadTimeZone(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.FixedZone("CST", 8*3600)
+	}
+	return loc
+}
+
+// MustTimeZone name为空时返回nil
+func MustTimeZone(name string) *time.Location {
+	if name == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic("timezone 格式错误:" + name)
+	}
+	return loc
+}
+
+// MustTimeZones 将 {快递公司编码: 时区名} 转换为时区表，用于国际快递按当地时间返回轨迹的情况
+func MustTimeZones(zones map[string]string) map[string]*time.Location {
+	var res = make(map[string]*time.Location)
+	for company, name := range zones {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			panic("timezone 格式错误:" + name)
+		}
+		res[strings.ToLower(company)] = loc
+	}
+	return res
+}
+
+// TimeZone 返回快递公司对应的时区，未配置时返回def，def为空时返回 DefaultTimeZone
+func TimeZone(zones map[string]*time.Location, company string, def *time.Location) *time.Location {
+	if loc, ok := zones[strings.ToLower(company)]; ok {
+		return loc
+	}
+	if def != nil {
+		return def
+	}
+	return DefaultTimeZone
+}
+
+func inZone(t *localTime.Time, loc *time.Location) *localTime.Time {
+	if t == nil {
+		return nil
+	}
+	v := time.Time(*t)
+	return localTime.Time(time.Date(v.Year(), v.Month(), v.Day(), v.Hour(), v.Minute(), v.Second(), v.Nanosecond(), loc)).Ptr()
+}
+
+// dedupKey 去除空白与标点后比较轨迹文本
+func dedupKey(info string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsPunct(r) {
+			return -1
+		}
+		return r
+	}, info)
+}
+
+// Normalize 将轨迹时间按loc解析，按时间倒序稳定排序(同一时间保持服务商顺序)，去除重复轨迹并重新计算 LastTrace*
+func Normalize(res *SubscribeRes, loc *time.Location) {
+	if res == nil {
+		return
+	}
+	if loc == nil {
+		loc = DefaultTimeZone
+	}
+	res.UpdateTime = inZone(res.UpdateTime, loc)
+
+	var traces = make([]Trace, 0, len(res.Traces))
+	for _, t := range res.Traces {
+		t.Time = inZone(t.Time, loc)
+		traces = append(traces, t)
+	}
+	res.Traces = traces
+	normalizeTraces(res)
+}
+
+// normalizeTraces 按时间倒序稳定排序，去除重复轨迹并重新计算 LastTrace*
+func normalizeTraces(res *SubscribeRes) {
+	var traces = res.Traces
+	sort.SliceStable(traces, func(i, j int) bool {
+		a, b := traces[i].Time, traces[j].Time
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		return a.After(*b)
+	})
+
+	var seen = make(traceSet)
+	var unique = make([]Trace, 0, len(traces))
+	for _, t := range traces {
+		if seen.contains(t) {
+			continue
+		}
+		seen.add(t)
+		unique = append(unique, t)
+	}
+	res.Traces = unique
+
+	res.LastTraceInfo = ""
+	res.LastTraceTime = nil
+	if len(unique) > 0 {
+		res.LastTraceInfo = unique[0].Info
+		res.LastTraceTime = unique[0].Time
+	}
+}
+
+// traceSet 按 dedupKey 与 NearDuplicateWindow 判断轨迹是否重复
+type traceSet map[string][]*localTime.Time
+
+func (s traceSet) add(t Trace) {
+	key := dedupKey(t.Info)
+	s[key] = append(s[key], t.Time)
+}
+
+func (s traceSet) contains(t Trace) bool {
+	for _, v := range s[dedupKey(t.Info)] {
+		if v == nil || t.Time == nil {
+			if v == nil && t.Time == nil {
+				return true
+			}
+			continue
+		}
+		d := v.Sub(*t.Time)
+		if d >= -NearDuplicateWindow && d <= NearDuplicateWindow {
+			return true
+		}
+	}
+	return false
+}
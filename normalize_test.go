@@ -0,0 +1,62 @@
+package expressTrace
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNormalize(t *testing.T) {
+	res := &SubscribeRes{
+		Traces: []Trace{
+			trace(t, "2022-06-29 22:30:20", "您的快件已发车"),
+			trace(t, "2022-06-30 10:34:52", "您的快件已由快递驿站代收"),
+			trace(t, "2022-06-30 07:18:05", "您的快件已到达【西安兴善营业部】"),
+			trace(t, "2022-06-30 07:18:05", "您的快件在【西安兴善营业部】收货完成"),
+			trace(t, "2022-06-30 07:17:30", "您的快件已到达 【西安兴善营业部】。"),
+			trace(t, "2022-06-30 10:34:52", "您的快件已由快递驿站代收"),
+		},
+	}
+	Normalize(res, nil)
+
+	var want = []string{
+		"您的快件已由快递驿站代收",
+		"您的快件已到达【西安兴善营业部】",
+		"您的快件在【西安兴善营业部】收货完成",
+		"您的快件已发车",
+	}
+	if len(res.Traces) != len(want) {
+		t.Fatalf("traces %+v", res.Traces)
+	}
+	for i, info := range want {
+		if res.Traces[i].Info != info {
+			t.Errorf("trace %d = %s, want %s", i, res.Traces[i].Info, info)
+		}
+	}
+	if res.LastTraceInfo != want[0] || res.LastTraceTime.String() != "2022-06-30 10:34:52" {
+		t.Fatalf("last trace %s %s", res.LastTraceInfo, res.LastTraceTime)
+	}
+	if _, offset := time.Time(*res.LastTraceTime).Zone(); offset != 8*3600 {
+		t.Fatalf("zone offset %d", offset)
+	}
+
+	zones := MustTimeZones(map[string]string{"UPS": "America/New_York"})
+	if TimeZone(zones, "ups", nil).String() != "America/New_York" || TimeZone(zones, "jd", nil) != DefaultTimeZone {
+		t.Fatal("unexpected zone lookup")
+	}
+}
+
+func TestParseTakeTime(t *testing.T) {
+	var cases = map[string]time.Duration{
+		"0天18小时56分": 18*time.Hour + 56*time.Minute,
+		"2天3小时":     51 * time.Hour,
+		"45分钟":      45 * time.Minute,
+	}
+	for s, want := range cases {
+		if got, ok := ParseTakeTime(s); !ok || got != want {
+			t.Errorf("ParseTakeTime(%q) = %s, %v", s, got, ok)
+		}
+	}
+	if _, ok := ParseTakeTime(""); ok {
+		t.Error("empty take time parsed")
+	}
+}
@@ -0,0 +1,28 @@
+package expressTrace
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+)
+
+// OutboxEntry 待发送到某个webhook的运单，同一运单同一webhook只保留 Sequence 最大的一条
+type OutboxEntry struct {
+	Url      string          `json:"url"`
+	OrderId  int64           `json:"orderId"`
+	Sequence int64           `json:"sequence"`
+	Body     json.RawMessage `json:"body"`
+}
+
+func (e *OutboxEntry) key() string {
+	return e.Url + "|" + strconv.FormatInt(e.OrderId, 10)
+}
+
+// Outbox 在确认服务商推送前保存待发送的webhook，发送成功后删除，进程重启后由 CallbackServer 继续发送
+type Outbox interface {
+	// Put 保存条目，已有 Sequence 更大的条目时忽略
+	Put(ctx context.Context, e *OutboxEntry) error
+	// Delete 删除条目，已被 Sequence 更大的条目替换时忽略
+	Delete(ctx context.Context, e *OutboxEntry) error
+	List(ctx context.Context) ([]*OutboxEntry, error)
+}
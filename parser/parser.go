@@ -0,0 +1,108 @@
+package parser
+
+import (
+	expressTrace "github.com/go-tron/express-trace"
+	"regexp"
+	"strings"
+)
+
+var (
+	courierPattern    = regexp.MustCompile(`(?:快递员|派件员|派送员|配送员|业务员|派件人|收件员)[：:]\s*([\p{Han}A-Za-z·]{1,10})`)
+	phonePattern      = regexp.MustCompile(`(?:联系电话|电话|手机号?|联系方式)[：:]?\s*(1[3-9]\d{9}|0\d{2,3}-?\d{7,8}|400-?\d{3}-?\d{4}|95\d{3,5})`)
+	mobilePattern     = regexp.MustCompile(`(?:^|\D)(1[3-9]\d{9})(?:\D|$)`)
+	bracketPattern    = regexp.MustCompile(`【([^【】]+)】`)
+	facilityPattern   = regexp.MustCompile(`(?:到达|离开|发往|在)\s*([\p{Han}A-Za-z0-9]{2,20}?(?:营业部|营业点|分拣中心|转运中心|集散中心|处理中心|中转场|分拨中心|网点|公司))`)
+	pickupCodePattern = regexp.MustCompile(`(?:取件码|提货码|取货码|取件号|取件密码)[：:为是\s]*([A-Za-z0-9-]{3,12})`)
+	stationPattern    = regexp.MustCompile(`(?:已由|由|存放至|存放在|存放于|放入|放至|送至|投递至|到达|在)([\p{Han}A-Za-z0-9]{0,12}?(?:驿站|丰巢快递柜|丰巢柜|丰巢|智能快递柜|智能柜|快递柜|代收点|快递超市))`)
+	stationNames      = regexp.MustCompile(`(菜鸟驿站|妈妈驿站|快递驿站|兔喜生活|丰巢快递柜|丰巢柜|丰巢|智能快递柜|快递柜|快递超市|代收点)`)
+)
+
+var stationWords = []string{"驿站", "快递柜", "智能柜", "丰巢", "代收点", "快递超市", "兔喜"}
+
+var stationEventWords = []string{"代收", "放入", "存放", "入柜", "投柜", "已到", "待取", "取件"}
+
+// Parse 从轨迹文本中解析快递员、电话、网点、取件码与驿站信息，没有可识别的信息时返回nil
+func Parse(info string) *expressTrace.TraceDetail {
+	detail := &expressTrace.TraceDetail{}
+
+	if m := courierPattern.FindStringSubmatch(info); m != nil {
+		detail.Courier = m[1]
+	}
+	if m := phonePattern.FindStringSubmatch(info); m != nil {
+		detail.CourierPhone = m[1]
+	} else if m := mobilePattern.FindStringSubmatch(info); m != nil {
+		detail.CourierPhone = m[1]
+	}
+	if m := pickupCodePattern.FindStringSubmatch(info); m != nil {
+		detail.PickupCode = m[1]
+	}
+
+	var seen = make(map[string]bool)
+	var station = ""
+	for _, m := range bracketPattern.FindAllStringSubmatch(info, -1) {
+		name := strings.TrimSpace(m[1])
+		if station == "" && containsAny(name, stationWords) {
+			station = name
+			continue
+		}
+		if name != "" && !seen[name] {
+			seen[name] = true
+			detail.Facilities = append(detail.Facilities, name)
+		}
+	}
+	if len(detail.Facilities) == 0 {
+		for _, m := range facilityPattern.FindAllStringSubmatch(info, -1) {
+			if !seen[m[1]] {
+				seen[m[1]] = true
+				detail.Facilities = append(detail.Facilities, m[1])
+			}
+		}
+	}
+
+	if station == "" {
+		if m := stationPattern.FindStringSubmatch(info); m != nil {
+			station = m[1]
+		} else if m := stationNames.FindStringSubmatch(info); m != nil {
+			station = m[1]
+		}
+	}
+	if station != "" {
+		detail.Station = station
+		detail.StationEvent = containsAny(info, stationEventWords)
+	}
+
+	if detail.Courier == "" && detail.CourierPhone == "" && len(detail.Facilities) == 0 &&
+		detail.PickupCode == "" && detail.Station == "" {
+		return nil
+	}
+	return detail
+}
+
+func containsAny(s string, words []string) bool {
+	for _, word := range words {
+		if strings.Contains(s, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// Annotate 解析结果中每条轨迹的文本并填充 Trace.Detail，服务商未返回快递员时取最新一条包含快递员的轨迹
+func Annotate(res *expressTrace.SubscribeRes) {
+	if res == nil {
+		return
+	}
+	for i := range res.Traces {
+		res.Traces[i].Detail = Parse(res.Traces[i].Info)
+	}
+	if res.Courier != "" || res.CourierPhone != "" {
+		return
+	}
+	for _, t := range res.Traces {
+		if t.Detail != nil && (t.Detail.Courier != "" || t.Detail.CourierPhone != "") {
+			res.Courier = t.Detail.Courier
+			res.CourierPhone = t.Detail.CourierPhone
+			return
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package parser
+
+import (
+	expressTrace "github.com/go-tron/express-trace"
+	"reflect"
+	"testing"
+)
+
+// 轨迹取自 fuqing_test.go 与 kuaidi100_test.go 中的真实推送
+var corpus = []struct {
+	info string
+	want *expressTrace.TraceDetail
+}{
+	{
+		info: "您的快件已由快递驿站代收，感谢您使用京东物流，期待再次为您服务",
+		want: &expressTrace.TraceDetail{Station: "快递驿站", StationEvent: true},
+	},
+	{
+		info: "您的快件正在派送中，请您准备签收（快递员：薛兵，联系电话：18740476340）。给您服务的快递员已完成新冠疫苗接种，祝您身体健康。疫情期间，为保证安全，京东快递每日对网点消毒，快递员佩戴口罩，请您安心！",
+		want: &expressTrace.TraceDetail{Courier: "薛兵", CourierPhone: "18740476340"},
+	},
+	{
+		info: "您的快件已到达【西安兴善营业部】",
+		want: &expressTrace.TraceDetail{Facilities: []string{"西安兴善营业部"}},
+	},
+	{
+		info: "您的快件在【西安兴善营业部】收货完成",
+		want: &expressTrace.TraceDetail{Facilities: []string{"西安兴善营业部"}},
+	},
+	{
+		info: "您的快件已发车",
+		want: nil,
+	},
+	{
+		info: "您的快件由【西安灞桥分拣中心】准备发往【西安兴善营业部】",
+		want: &expressTrace.TraceDetail{Facilities: []string{"西安灞桥分拣中心", "西安兴善营业部"}},
+	},
+	{
+		info: "您的快件在【西安灞桥分拣中心】分拣完成",
+		want: &expressTrace.TraceDetail{Facilities: []string{"西安灞桥分拣中心"}},
+	},
+	{
+		info: "您的快件已到达【西安灞桥分拣中心】",
+		want: &expressTrace.TraceDetail{Facilities: []string{"西安灞桥分拣中心"}},
+	},
+	{
+		info: "快件已到达西安雁塔区电子城营业部",
+		want: &expressTrace.TraceDetail{Facilities: []string{"西安雁塔区电子城营业部"}},
+	},
+	{
+		info: "您的快件已存放至【菜鸟驿站·兴善寺东街店】，请凭取件码 5-2-3011 及时领取",
+		want: &expressTrace.TraceDetail{PickupCode: "5-2-3011", Station: "菜鸟驿站·兴善寺东街店", StationEvent: true},
+	},
+	{
+		info: "快件已放入丰巢快递柜，取件码：86731205，如有疑问请联系派件员：王五，电话：029-88886666",
+		want: &expressTrace.TraceDetail{Courier: "王五", CourierPhone: "029-88886666", PickupCode: "86731205", Station: "丰巢快递柜", StationEvent: true},
+	},
+}
+
+func TestParse(t *testing.T) {
+	for _, c := range corpus {
+		got := Parse(c.info)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Parse(%q)\n got %+v\nwant %+v", c.info, got, c.want)
+		}
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	res := &expressTrace.SubscribeRes{
+		Traces: []expressTrace.Trace{
+			{Info: corpus[0].info},
+			{Info: corpus[4].info},
+		},
+	}
+	Annotate(res)
+	if res.Traces[0].Detail == nil || !res.Traces[0].Detail.StationEvent {
+		t.Fatalf("unexpected detail %+v", res.Traces[0].Detail)
+	}
+	if res.Traces[1].Detail != nil {
+		t.Fatalf("unexpected detail %+v", res.Traces[1].Detail)
+	}
+}
@@ -0,0 +1,279 @@
+package expressTrace
+
+import (
+	"context"
+	baseError "github.com/go-tron/base-error"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrorSubscription = baseError.Factory("3017", "订阅记录不存在:{}")
+	ErrorProvider     = baseError.SystemFactory("3018", "快递查询服务不存在:{}")
+)
+
+// IsRetryable 连接失败(3012)与返回解析失败(3013)时可切换到备用服务商
+func IsRetryable(err error) bool {
+	e, ok := err.(*baseError.Error)
+	return ok && (e.Code == "3012" || e.Code == "3013")
+}
+
+// Policy 根据快递公司返回依次尝试的服务商名称
+type Policy interface {
+	Select(company string) []string
+}
+
+type failover []string
+
+// Failover 按顺序使用服务商，前一个不可用时使用下一个
+func Failover(names ...string) Policy {
+	return failover(names)
+}
+
+func (p failover) Select(company string) []string {
+	return append([]string(nil), p...)
+}
+
+type weighted struct {
+	names   []string
+	weights []int
+	total   int
+}
+
+// Weighted 按权重随机选择首选服务商，其余服务商按权重从高到低作为备用
+func Weighted(weights map[string]int) Policy {
+	p := &weighted{}
+	for name, weight := range weights {
+		if weight <= 0 {
+			continue
+		}
+		p.names = append(p.names, name)
+	}
+	sort.Slice(p.names, func(i, j int) bool {
+		if weights[p.names[i]] != weights[p.names[j]] {
+			return weights[p.names[i]] > weights[p.names[j]]
+		}
+		return p.names[i] < p.names[j]
+	})
+	for _, name := range p.names {
+		p.weights = append(p.weights, weights[name])
+		p.total += weights[name]
+	}
+	return p
+}
+
+func (p *weighted) Select(company string) []string {
+	if p.total == 0 {
+		return nil
+	}
+	n := rand.Intn(p.total)
+	first := 0
+	for i, weight := range p.weights {
+		if n < weight {
+			first = i
+			break
+		}
+		n -= weight
+	}
+	names := []string{p.names[first]}
+	for i, name := range p.names {
+		if i != first {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+type byCompany struct {
+	routes   map[string]string
+	fallback Policy
+}
+
+// ByCompany 按快递公司指定服务商(如 {"jd": "fuqing"})，未匹配或指定服务商不可用时使用fallback。
+// 快递公司可使用统一编码或服务商编码，均按 CanonicalCompany 转换为统一编码匹配
+func ByCompany(routes map[string]string, fallback Policy) Policy {
+	var r = make(map[string]string)
+	for company, name := range routes {
+		r[CanonicalCompany("", company)] = name
+	}
+	return &byCompany{routes: r, fallback: fallback}
+}
+
+func (p *byCompany) Select(company string) []string {
+	var names []string
+	if fallback := p.fallback; fallback != nil {
+		names = fallback.Select(company)
+	}
+	name, ok := p.routes[CanonicalCompany("", company)]
+	if !ok {
+		return names
+	}
+	var selected = []string{name}
+	for _, v := range names {
+		if v != name {
+			selected = append(selected, v)
+		}
+	}
+	return selected
+}
+
+// Subscriptions 记录订阅所使用的服务商，用于将推送分发给对应的服务商。
+// 多实例或需要重启的部署应使用持久化实现，未找到记录时 Router 会依次尝试各服务商
+type Subscriptions interface {
+	Set(ctx context.Context, orderId int64, provider string) error
+	Get(ctx context.Context, orderId int64) (string, error)
+}
+
+type MemorySubscriptions struct {
+	mu   sync.RWMutex
+	data map[int64]string
+}
+
+func NewMemorySubscriptions() *MemorySubscriptions {
+	return &MemorySubscriptions{data: make(map[int64]string)}
+}
+
+func (s *MemorySubscriptions) Set(ctx context.Context, orderId int64, provider string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[orderId] = provider
+	return nil
+}
+
+func (s *MemorySubscriptions) Get(ctx context.Context, orderId int64) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data[orderId], nil
+}
+
+var _ ExpressTrace = (*Router)(nil)
+
+// Router 将多个服务商组合为一个 ExpressTrace
+type Router struct {
+	Providers     map[string]ExpressTrace
+	Policy        Policy
+	Subscriptions Subscriptions //默认 MemorySubscriptions，重启后丢失
+	Retryable     func(error) bool
+}
+
+func NewRouter(c *Router) *Router {
+	if c == nil {
+		panic("config 必须设置")
+	}
+	if len(c.Providers) == 0 {
+		panic("Providers 必须设置")
+	}
+	if c.Policy == nil {
+		var names []string
+		for name := range c.Providers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		c.Policy = Failover(names...)
+	}
+	if c.Subscriptions == nil {
+		c.Subscriptions = NewMemorySubscriptions()
+	}
+	if c.Retryable == nil {
+		c.Retryable = IsRetryable
+	}
+	return c
+}
+
+func (c *Router) Provider(name string) (ExpressTrace, error) {
+	provider, ok := c.Providers[name]
+	if !ok {
+		return nil, ErrorProvider(name)
+	}
+	return provider, nil
+}
+
+// each 按 Policy 依次调用服务商，快递公司经 CanonicalCompany 转换为统一编码，未指定时按单号识别
+func (c *Router) each(number string, company string, fn func(string, ExpressTrace) error) error {
+	company = CanonicalCompany(number, company)
+	names := c.Policy.Select(company)
+	if len(names) == 0 {
+		return ErrorProvider(company)
+	}
+	var err error
+	for _, name := range names {
+		provider, e := c.Provider(name)
+		if e != nil {
+			err = e
+			continue
+		}
+		if err = fn(name, provider); err == nil || !c.Retryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// Query 未指定快递公司时按单号识别并写入 req.Company
+func (c *Router) Query(ctx context.Context, req *QueryReq) (res *SubscribeRes, err error) {
+	err = c.each(req.Number, req.GuessCompany(), func(name string, provider ExpressTrace) error {
+		res, err = provider.Query(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Subscribe 未指定快递公司时按单号识别并写入 req.Company
+func (c *Router) Subscribe(ctx context.Context, req *SubscribeReq) error {
+	return c.each(req.Number, req.GuessCompany(), func(name string, provider ExpressTrace) error {
+		if err := provider.Subscribe(ctx, req); err != nil {
+			return err
+		}
+		return c.Subscriptions.Set(ctx, req.OrderId, name)
+	})
+}
+
+func (c *Router) SubscribeCallback(ctx context.Context, orderId int64, data map[string]string) (*SubscribeRes, error) {
+	name, err := c.Subscriptions.Get(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return c.detectCallback(ctx, orderId, data)
+	}
+	provider, err := c.Provider(name)
+	if err != nil {
+		return nil, err
+	}
+	return provider.SubscribeCallback(ctx, orderId, data)
+}
+
+// detectCallback 订阅记录不存在时(如重启后内存记录丢失)依次尝试各服务商，推送参数与签名均由服务商验证，
+// 成功后补充订阅记录。各服务商均缺少参数(3015)时返回 ErrorSubscription
+func (c *Router) detectCallback(ctx context.Context, orderId int64, data map[string]string) (*SubscribeRes, error) {
+	var names []string
+	for name := range c.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var err error
+	for _, name := range names {
+		res, e := c.Providers[name].SubscribeCallback(ctx, orderId, data)
+		if e == nil {
+			if e := c.Subscriptions.Set(ctx, orderId, name); e != nil {
+				return nil, e
+			}
+			return res, nil
+		}
+		if be, ok := e.(*baseError.Error); ok && be.Code == "3015" {
+			continue
+		}
+		if err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, ErrorSubscription(orderId)
+}
@@ -0,0 +1,136 @@
+package expressTrace
+
+import (
+	"context"
+	baseError "github.com/go-tron/base-error"
+	"testing"
+)
+
+type stubProvider struct {
+	name  string
+	err   error
+	calls int
+}
+
+func (p *stubProvider) Query(ctx context.Context, req *QueryReq) (*SubscribeRes, error) {
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &SubscribeRes{OrderId: req.OrderId, Number: req.Number, CompanyCode: p.name}, nil
+}
+
+func (p *stubProvider) Subscribe(ctx context.Context, req *SubscribeReq) error {
+	p.calls++
+	return p.err
+}
+
+// SubscribeCallback 只接受 data["provider"] 为自身名称的推送，模拟服务商推送参数各不相同
+func (p *stubProvider) SubscribeCallback(ctx context.Context, orderId int64, data map[string]string) (*SubscribeRes, error) {
+	p.calls++
+	if data["provider"] != p.name {
+		return nil, baseError.New("3015", "缺少参数")
+	}
+	return &SubscribeRes{OrderId: orderId, CompanyCode: p.name}, nil
+}
+
+func TestRouter_Failover(t *testing.T) {
+	primary := &stubProvider{name: "primary", err: baseError.System("3012", "快递查询服务连接失败")}
+	backup := &stubProvider{name: "backup"}
+	router := NewRouter(&Router{
+		Providers: map[string]ExpressTrace{"primary": primary, "backup": backup},
+		Policy:    Failover("primary", "backup"),
+	})
+
+	if err := router.Subscribe(context.Background(), &SubscribeReq{OrderId: 1, Number: "JD0076810060555"}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := router.SubscribeCallback(context.Background(), 1, map[string]string{"provider": "backup"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.CompanyCode != "backup" || primary.calls != 1 {
+		t.Fatalf("callback dispatched to %s, primary calls %d", res.CompanyCode, primary.calls)
+	}
+
+	_, err = router.SubscribeCallback(context.Background(), 2, map[string]string{"provider": "unknown"})
+	if e, ok := err.(*baseError.Error); !ok || e.Code != "3017" {
+		t.Fatalf("expected unknown subscription error, got %v", err)
+	}
+
+	primary.err = baseError.New("3014", "快递单号错误")
+	calls := backup.calls
+	if _, err := router.Query(context.Background(), &QueryReq{Number: "JD0076810060555"}); err == nil || backup.calls != calls {
+		t.Fatalf("non-retryable error should not fail over, err %v", err)
+	}
+}
+
+// TestRouter_CallbackWithoutSubscription 重启后订阅记录丢失时，推送由能验证通过的服务商处理并补充记录
+func TestRouter_CallbackWithoutSubscription(t *testing.T) {
+	fuqing := &stubProvider{name: "fuqing"}
+	kuaidi100 := &stubProvider{name: "kuaidi100"}
+	router := NewRouter(&Router{
+		Providers: map[string]ExpressTrace{"fuqing": fuqing, "kuaidi100": kuaidi100},
+	})
+	res, err := router.SubscribeCallback(context.Background(), 33333, map[string]string{"provider": "kuaidi100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.CompanyCode != "kuaidi100" {
+		t.Fatalf("callback dispatched to %s", res.CompanyCode)
+	}
+	if name, _ := router.Subscriptions.Get(context.Background(), 33333); name != "kuaidi100" {
+		t.Fatalf("subscription %q", name)
+	}
+
+	router.SubscribeCallback(context.Background(), 33333, map[string]string{"provider": "kuaidi100"})
+	if fuqing.calls != 1 || kuaidi100.calls != 2 {
+		t.Fatalf("calls fuqing %d, kuaidi100 %d", fuqing.calls, kuaidi100.calls)
+	}
+}
+
+func TestRouter_ByCompany(t *testing.T) {
+	policy := ByCompany(map[string]string{"JD": "fuqing", "SFEXPRESS": "fuqing"}, Failover("kuaidi100", "fuqing"))
+	if names := policy.Select("jd"); names[0] != "fuqing" || len(names) != 2 {
+		t.Fatalf("jd routed to %v", names)
+	}
+	//服务商编码与统一编码指向同一快递公司
+	for _, company := range []string{"shunfeng", "SFEXPRESS", "sfexpress"} {
+		if names := policy.Select(company); names[0] != "fuqing" {
+			t.Fatalf("%s routed to %v", company, names)
+		}
+	}
+	if names := policy.Select("yunda"); names[0] != "kuaidi100" {
+		t.Fatalf("yunda routed to %v", names)
+	}
+
+	//未指定快递公司时按单号识别
+	fuqing := &stubProvider{name: "fuqing"}
+	kuaidi100 := &stubProvider{name: "kuaidi100"}
+	router := NewRouter(&Router{
+		Providers: map[string]ExpressTrace{"fuqing": fuqing, "kuaidi100": kuaidi100},
+		Policy:    policy,
+	})
+	res, err := router.Query(context.Background(), &QueryReq{Number: "SF1234567890123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.CompanyCode != "fuqing" {
+		t.Fatalf("SF number routed to %s", res.CompanyCode)
+	}
+}
+
+func TestRouter_Weighted(t *testing.T) {
+	policy := Weighted(map[string]int{"fuqing": 1, "kuaidi100": 3, "disabled": 0})
+	var count = make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		names := policy.Select("")
+		if len(names) != 2 {
+			t.Fatalf("selected %v", names)
+		}
+		count[names[0]]++
+	}
+	if count["kuaidi100"] < count["fuqing"] {
+		t.Fatalf("unexpected split %v", count)
+	}
+}
@@ -0,0 +1,238 @@
+package expressTrace
+
+import (
+	"context"
+	"github.com/go-tron/logger"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+var SystemClock Clock = systemClock{}
+
+// IntervalFunc 返回运单距下次查询的间隔，返回false时不再查询
+type IntervalFunc func(res *SubscribeRes, now time.Time) (time.Duration, bool)
+
+// DefaultInterval 按轨迹更新时间逐步降低查询频率，揽收后每小时、派件中每半小时至少查询一次，终态不再查询
+func DefaultInterval(res *SubscribeRes, now time.Time) (time.Duration, bool) {
+	if res.Status.Terminal() {
+		return 0, false
+	}
+	interval := 2 * time.Hour
+	if res.LastTraceTime != nil {
+		since := now.Sub(time.Time(*res.LastTraceTime))
+		switch {
+		case since < 24*time.Hour:
+			interval = time.Hour
+		case since < 72*time.Hour:
+			interval = 4 * time.Hour
+		case since < 15*24*time.Hour:
+			interval = 12 * time.Hour
+		default:
+			interval = 24 * time.Hour
+		}
+	}
+	var limit time.Duration
+	switch res.Status {
+	case StatusDelivering:
+		limit = 30 * time.Minute
+	case StatusAccepted:
+		limit = time.Hour
+	}
+	if limit > 0 && interval > limit {
+		interval = limit
+	}
+	return interval, true
+}
+
+type schedule struct {
+	next time.Time
+	done bool
+}
+
+// Scheduler 通过 Query 定时刷新 Store 中未到达终态的运单，结果与推送一样交给 Handler 处理
+type Scheduler struct {
+	Provider    ExpressTrace
+	Store       Store
+	Handler     CallbackFunc
+	Clock       Clock                    //默认 SystemClock
+	Interval    IntervalFunc             //默认 DefaultInterval
+	Filter      func(*SubscribeRes) bool //返回false的运单不查询，如已开通推送的快递公司
+	Tick        time.Duration            //扫描间隔，默认1分钟
+	Jitter      float64                  //查询间隔随机浮动比例，默认0.1，小于0时不浮动
+	Concurrency int                      //同时查询的最大数量，默认4
+	Logger      logger.Logger
+	mu          sync.Mutex
+	schedules   map[int64]*schedule
+	rand        *rand.Rand
+}
+
+func NewScheduler(c *Scheduler) *Scheduler {
+	if c == nil {
+		panic("config 必须设置")
+	}
+	if c.Provider == nil {
+		panic("Provider 必须设置")
+	}
+	if c.Store == nil {
+		panic("Store 必须设置")
+	}
+	if c.Handler == nil {
+		panic("Handler 必须设置")
+	}
+	if c.Logger == nil {
+		panic("Logger 必须设置")
+	}
+	if c.Clock == nil {
+		c.Clock = SystemClock
+	}
+	if c.Interval == nil {
+		c.Interval = DefaultInterval
+	}
+	if c.Tick == 0 {
+		c.Tick = time.Minute
+	}
+	if c.Jitter == 0 {
+		c.Jitter = 0.1
+	}
+	if c.Concurrency == 0 {
+		c.Concurrency = 4
+	}
+	if c.Concurrency < 0 {
+		panic("Concurrency 不能小于0")
+	}
+	c.schedules = make(map[int64]*schedule)
+	c.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	return c
+}
+
+// Run 每隔 Tick 执行一次 RunOnce，直到ctx结束
+func (s *Scheduler) Run(ctx context.Context) error {
+	for {
+		if err := s.RunOnce(ctx); err != nil {
+			s.Logger.Error("轮询运单失败", s.Logger.Field("error", err))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.Clock.After(s.Tick):
+		}
+	}
+}
+
+// RunOnce 查询所有到期的运单并等待完成，首次出现的运单立即查询
+func (s *Scheduler) RunOnce(ctx context.Context) error {
+	list, err := s.Store.ListActive(ctx)
+	if err != nil {
+		return err
+	}
+	now := s.Clock.Now()
+
+	var due = make([]*SubscribeRes, 0)
+	var active = make(map[int64]bool)
+	s.mu.Lock()
+	for _, res := range list {
+		if s.Filter != nil && !s.Filter(res) {
+			continue
+		}
+		active[res.OrderId] = true
+		sc, ok := s.schedules[res.OrderId]
+		if !ok {
+			sc = &schedule{next: now}
+			s.schedules[res.OrderId] = sc
+		}
+		if !sc.done && !sc.next.After(now) {
+			due = append(due, res)
+		}
+	}
+	for orderId := range s.schedules {
+		if !active[orderId] {
+			delete(s.schedules, orderId)
+		}
+	}
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	var sem = make(chan struct{}, s.Concurrency)
+	for _, res := range due {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(res *SubscribeRes) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			s.poll(ctx, res)
+		}(res)
+	}
+	wg.Wait()
+	return nil
+}
+
+func (s *Scheduler) poll(ctx context.Context, res *SubscribeRes) {
+	result, err := s.Provider.Query(ctx, &QueryReq{
+		OrderId: res.OrderId,
+		Number:  res.Number,
+		Company: res.CompanyCode,
+	})
+	if err != nil {
+		s.Logger.Error("查询运单失败",
+			s.Logger.Field("orderId", res.OrderId),
+			s.Logger.Field("number", res.Number),
+			s.Logger.Field("error", err),
+		)
+		result = res
+	} else {
+		if result.OrderId == 0 {
+			result.OrderId = res.OrderId
+		}
+		if err := s.Handler(ctx, result); err != nil {
+			s.Logger.Error("处理查询结果失败",
+				s.Logger.Field("orderId", res.OrderId),
+				s.Logger.Field("error", err),
+			)
+		}
+	}
+	s.reschedule(res.OrderId, result)
+}
+
+func (s *Scheduler) reschedule(orderId int64, res *SubscribeRes) {
+	now := s.Clock.Now()
+	interval, ok := s.Interval(res, now)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc, exists := s.schedules[orderId]
+	if !exists {
+		sc = &schedule{}
+		s.schedules[orderId] = sc
+	}
+	if !ok {
+		sc.done = true
+		return
+	}
+	if s.Jitter > 0 {
+		interval += time.Duration((s.rand.Float64()*2 - 1) * s.Jitter * float64(interval))
+	}
+	sc.next = now.Add(interval)
+}
@@ -0,0 +1,133 @@
+package expressTrace
+
+import (
+	"context"
+	"github.com/go-tron/logger"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- c.Now().Add(d)
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestScheduler(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	store.Save(ctx, &SubscribeRes{OrderId: 1, Number: "JD0076810060555", Status: StatusPending})
+	store.Save(ctx, &SubscribeRes{OrderId: 2, Number: "JD0076810087472", Status: StatusDelivered})
+
+	provider := &stubProvider{name: "jd"}
+	clock := &fakeClock{now: time.Date(2022, 6, 30, 10, 0, 0, 0, time.UTC)}
+	var mu sync.Mutex
+	var received []*SubscribeRes
+	scheduler := NewScheduler(&Scheduler{
+		Provider: provider,
+		Store:    store,
+		Clock:    clock,
+		Handler: func(ctx context.Context, res *SubscribeRes) error {
+			mu.Lock()
+			defer mu.Unlock()
+			received = append(received, res)
+			return nil
+		},
+		Logger: logger.NewZap("scheduler", "error"),
+	})
+
+	if err := scheduler.RunOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if provider.calls != 1 || len(received) != 1 || received[0].OrderId != 1 {
+		t.Fatalf("calls %d, received %v", provider.calls, received)
+	}
+
+	scheduler.RunOnce(ctx)
+	if provider.calls != 1 {
+		t.Fatalf("polled before interval elapsed")
+	}
+
+	clock.Advance(3 * time.Hour)
+	scheduler.RunOnce(ctx)
+	if provider.calls != 2 {
+		t.Fatalf("not polled after interval, calls %d", provider.calls)
+	}
+}
+
+func TestDefaultInterval(t *testing.T) {
+	now := time.Now()
+	recent := trace(t, now.Add(-time.Hour).Format("2006-01-02 15:04:05"), "")
+	stale := trace(t, now.Add(-10*24*time.Hour).Format("2006-01-02 15:04:05"), "")
+
+	fast, _ := DefaultInterval(&SubscribeRes{Status: StatusInTransit, LastTraceTime: recent.Time}, now)
+	slow, _ := DefaultInterval(&SubscribeRes{Status: StatusInTransit, LastTraceTime: stale.Time}, now)
+	if fast >= slow {
+		t.Fatalf("recent %s should poll more often than stale %s", fast, slow)
+	}
+	if _, ok := DefaultInterval(&SubscribeRes{Status: StatusDelivered}, now); ok {
+		t.Fatal("delivered shipment should not be polled")
+	}
+
+	//揽收后与派件中即使轨迹较久未更新也更频繁查询
+	for status, limit := range map[Status]time.Duration{
+		StatusAccepted:   time.Hour,
+		StatusDelivering: 30 * time.Minute,
+	} {
+		if interval, _ := DefaultInterval(&SubscribeRes{Status: status, LastTraceTime: stale.Time}, now); interval > limit {
+			t.Errorf("%s: interval %s", status, interval)
+		}
+	}
+}
+
+func TestNewScheduler_Options(t *testing.T) {
+	newScheduler := func(c *Scheduler) *Scheduler {
+		c.Provider = &stubProvider{name: "jd"}
+		c.Store = NewMemoryStore()
+		c.Handler = func(ctx context.Context, res *SubscribeRes) error { return nil }
+		c.Logger = logger.NewZap("scheduler", "error")
+		return NewScheduler(c)
+	}
+
+	//Jitter小于0时按固定间隔查询
+	clock := &fakeClock{now: time.Date(2022, 6, 30, 10, 0, 0, 0, time.UTC)}
+	s := newScheduler(&Scheduler{
+		Clock:    clock,
+		Jitter:   -1,
+		Interval: func(*SubscribeRes, time.Time) (time.Duration, bool) { return time.Hour, true },
+	})
+	for i := 0; i < 10; i++ {
+		s.reschedule(1, &SubscribeRes{OrderId: 1})
+		if next := s.schedules[1].next; !next.Equal(clock.now.Add(time.Hour)) {
+			t.Fatalf("jitter applied: %s", next)
+		}
+	}
+	if s := newScheduler(&Scheduler{}); s.Jitter != 0.1 || s.Concurrency != 4 {
+		t.Errorf("unexpected defaults %v %d", s.Jitter, s.Concurrency)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for negative Concurrency")
+		}
+	}()
+	newScheduler(&Scheduler{Concurrency: -1})
+}
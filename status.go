@@ -0,0 +1,68 @@
+package expressTrace
+
+// Status 跨服务商统一的物流状态，各服务商的原始状态码保存在 SubscribeRes.State
+type Status string
+
+const (
+	StatusUnknown    Status = "unknown"    //无法识别的状态
+	StatusInvalid    Status = "invalid"    //单号或快递公司错误
+	StatusPending    Status = "pending"    //暂无轨迹
+	StatusAccepted   Status = "accepted"   //已揽收
+	StatusInTransit  Status = "inTransit"  //运输中，包含转投、清关
+	StatusDelivering Status = "delivering" //派件中
+	StatusDelivered  Status = "delivered"  //已签收
+	StatusException  Status = "exception"  //疑难件、问题件、派送失败
+	StatusReturning  Status = "returning"  //退回途中
+	StatusReturned   Status = "returned"   //退件已签收
+	StatusRejected   Status = "rejected"   //收件人拒签
+)
+
+var statuses = []Status{
+	StatusUnknown,
+	StatusInvalid,
+	StatusPending,
+	StatusAccepted,
+	StatusInTransit,
+	StatusDelivering,
+	StatusDelivered,
+	StatusException,
+	StatusReturning,
+	StatusReturned,
+	StatusRejected,
+}
+
+func Statuses() []Status {
+	return append([]Status(nil), statuses...)
+}
+
+func (s Status) Valid() bool {
+	for _, v := range statuses {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Terminal 终态的运单不会再产生新的轨迹
+func (s Status) Terminal() bool {
+	switch s {
+	case StatusInvalid, StatusDelivered, StatusReturned, StatusRejected:
+		return true
+	}
+	return false
+}
+
+func (s Status) String() string {
+	return string(s)
+}
+
+// SubscriptionStatus 推送订阅的监控状态
+type SubscriptionStatus string
+
+const (
+	SubscriptionPolling   SubscriptionStatus = "polling"   //监控中
+	SubscriptionShutdown  SubscriptionStatus = "shutdown"  //已签收等终态，结束监控
+	SubscriptionAbort     SubscriptionStatus = "abort"     //监控中止，如3天无轨迹、60天无变化
+	SubscriptionUpdateAll SubscriptionStatus = "updateall" //重新推送全部轨迹
+)
@@ -0,0 +1,170 @@
+package expressTrace
+
+import (
+	"context"
+	localTime "github.com/go-tron/local-time"
+	"sync"
+)
+
+// Store 保存运单的最新轨迹，Load/LoadByNumber 未找到时返回 nil, nil
+type Store interface {
+	Save(ctx context.Context, res *SubscribeRes) error
+	Load(ctx context.Context, orderId int64) (*SubscribeRes, error)
+	LoadByNumber(ctx context.Context, number string) (*SubscribeRes, error)
+	ListActive(ctx context.Context) ([]*SubscribeRes, error)
+}
+
+// Pinger 由 Store 实现时 CallbackServer 的 ReadyPath 通过 Ping 检查存储是否可用，未实现时使用 Load
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Change 一次推送或查询相对已保存运单的变化
+type Change struct {
+	Shipment *SubscribeRes `json:"shipment"`
+	Added    []Trace       `json:"added"` //新增的轨迹
+	From     Status        `json:"from"`  //合并前的状态，首次保存时为空
+	To       Status        `json:"to"`
+}
+
+func (c *Change) StatusChanged() bool {
+	return c.From != c.To
+}
+
+func clone(res *SubscribeRes) *SubscribeRes {
+	if res == nil {
+		return nil
+	}
+	c := *res
+	c.Traces = append([]Trace(nil), res.Traces...)
+	return &c
+}
+
+// Updater 由 Store 实现时 Merge 在 Update 的锁内读取并保存运单，orderId为0时按number查找；
+// fn 返回的运单由 Update 保存，fn 返回错误时不保存
+type Updater interface {
+	Update(ctx context.Context, orderId int64, number string, fn func(old *SubscribeRes) (*SubscribeRes, error)) error
+}
+
+// updateMu 串行未实现 Updater 的 Store 的读取与保存，仅在同一进程内有效
+var updateMu sync.Mutex
+
+// Merge 将推送或查询结果合并到已保存的运单，服务商未返回的历史轨迹会被保留，
+// 合并后的轨迹按 Normalize 的规则排序去重。读取与保存之间加锁，同一运单的并发合并不会互相覆盖
+func Merge(ctx context.Context, store Store, res *SubscribeRes) (*Change, error) {
+	var change *Change
+	err := update(ctx, store, res.OrderId, res.Number, func(old *SubscribeRes) (*SubscribeRes, error) {
+		var err error
+		change, err = merge(old, res)
+		if err != nil {
+			return nil, err
+		}
+		return change.Shipment, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// update 在 Updater 或 updateMu 的锁内读取运单，fn 返回的运单保存到store
+func update(ctx context.Context, store Store, orderId int64, number string, fn func(old *SubscribeRes) (*SubscribeRes, error)) error {
+	if updater, ok := store.(Updater); ok {
+		return updater.Update(ctx, orderId, number, fn)
+	}
+
+	updateMu.Lock()
+	defer updateMu.Unlock()
+	old, err := load(ctx, store, orderId, number)
+	if err != nil {
+		return err
+	}
+	res, err := fn(old)
+	if err != nil {
+		return err
+	}
+	return store.Save(ctx, res)
+}
+
+func merge(old *SubscribeRes, res *SubscribeRes) (*Change, error) {
+	merged := clone(res)
+	change := &Change{
+		Shipment: merged,
+		Added:    make([]Trace, 0),
+	}
+
+	var existing = make(traceSet)
+	if old != nil {
+		change.From = old.Status
+		if merged.OrderId == 0 {
+			merged.OrderId = old.OrderId
+		}
+		for _, t := range old.Traces {
+			existing.add(t)
+		}
+		merged.Traces = append(merged.Traces, old.Traces...)
+		merged.Notified = old.Notified
+		//重新订阅的次数由 CallbackServer 记录，同一次中止的重推保留已重新订阅的标记
+		if merged.Resubscribes < old.Resubscribes {
+			merged.Resubscribes = old.Resubscribes
+		}
+		if merged.Subscription == old.Subscription {
+			merged.Resubscribed = merged.Resubscribed || old.Resubscribed
+		}
+		//已到达终态或推送的轨迹比已保存的旧(如服务商重推的旧推送)时保留原状态
+		if old.Status.Terminal() || stale(res, old) {
+			merged.Status = old.Status
+			merged.State = old.State
+			merged.Signed = old.Signed
+		}
+	}
+	if merged.OrderId == 0 {
+		return nil, ErrorSubscription(res.Number)
+	}
+	change.To = merged.Status
+
+	normalizeTraces(merged)
+	for _, t := range merged.Traces {
+		if !existing.contains(t) {
+			change.Added = append(change.Added, t)
+		}
+	}
+	if old != nil {
+		merged.Sequence = old.Sequence
+	}
+	if old == nil || len(change.Added) > 0 || change.StatusChanged() {
+		merged.Sequence++
+	}
+	return change, nil
+}
+
+// stale res的最新轨迹早于old的最新轨迹，res没有轨迹而old有时同样视为过期
+func stale(res *SubscribeRes, old *SubscribeRes) bool {
+	oldLatest := latestTraceTime(old.Traces)
+	if oldLatest == nil {
+		return false
+	}
+	latest := latestTraceTime(res.Traces)
+	return latest == nil || latest.Before(*oldLatest)
+}
+
+func latestTraceTime(traces []Trace) *localTime.Time {
+	var latest *localTime.Time
+	for _, t := range traces {
+		if t.Time != nil && (latest == nil || t.Time.After(*latest)) {
+			latest = t.Time
+		}
+	}
+	return latest
+}
+
+// load orderId为0时按number查找
+func load(ctx context.Context, store Store, orderId int64, number string) (*SubscribeRes, error) {
+	if orderId != 0 {
+		return store.Load(ctx, orderId)
+	}
+	if number != "" {
+		return store.LoadByNumber(ctx, number)
+	}
+	return nil, nil
+}
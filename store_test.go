@@ -0,0 +1,330 @@
+package expressTrace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	localTime "github.com/go-tron/local-time"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func trace(t *testing.T, value string, info string) Trace {
+	tm, err := localTime.ParseLocal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Trace{Time: &tm, Info: info}
+}
+
+func TestMerge(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileStore(filepath.Join(t.TempDir(), "shipments.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := &SubscribeRes{
+		OrderId: 33334,
+		Number:  "JD0076810087472",
+		Status:  StatusInTransit,
+		Traces: []Trace{
+			trace(t, "2022-06-29 22:30:20", "您的快件已发车"),
+		},
+	}
+	change, err := Merge(ctx, store, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(change.Added) != 1 || change.From != "" || change.To != StatusInTransit {
+		t.Fatalf("unexpected change %+v", change)
+	}
+
+	second := &SubscribeRes{
+		Number: "JD0076810087472",
+		Status: StatusDelivered,
+		Traces: []Trace{
+			trace(t, "2022-06-30 10:34:52", "您的快件已由快递驿站代收"),
+		},
+	}
+	change, err = Merge(ctx, store, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(change.Added) != 1 || !change.StatusChanged() || change.Shipment.OrderId != 33334 || len(change.Shipment.Traces) != 2 {
+		t.Fatalf("unexpected change %+v", change)
+	}
+
+	reopened, err := NewFileStore(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := reopened.LoadByNumber(ctx, "JD0076810087472")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res == nil || res.Status != StatusDelivered || len(res.Traces) != 2 {
+		t.Fatalf("unexpected shipment %+v", res)
+	}
+	active, err := reopened.ListActive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 0 {
+		t.Fatalf("delivered shipment listed as active")
+	}
+
+	if _, err := Merge(ctx, store, &SubscribeRes{Number: "unknown"}); err == nil {
+		t.Fatal("expected error for shipment without orderId")
+	}
+}
+
+// TestMerge_NearDuplicate 服务商重推时文本或时间略有差异的轨迹不计为新增，合并结果按时间倒序
+func TestMerge_NearDuplicate(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	first := &SubscribeRes{
+		OrderId: 33334,
+		Number:  "JD0076810087472",
+		Status:  StatusInTransit,
+		Traces: []Trace{
+			trace(t, "2022-06-29 15:38:09", "您的快件已到达【西安灞桥分拣中心】"),
+			trace(t, "2022-06-29 22:30:20", "您的快件已发车"),
+		},
+	}
+	if _, err := Merge(ctx, store, first); err != nil {
+		t.Fatal(err)
+	}
+
+	change, err := Merge(ctx, store, &SubscribeRes{
+		OrderId: 33334,
+		Number:  "JD0076810087472",
+		Status:  StatusDelivering,
+		Traces: []Trace{
+			trace(t, "2022-06-30 08:12:00", "您的快件正在派送中"),
+			trace(t, "2022-06-29 22:31:00", "您的快件已发车。"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(change.Added) != 1 || change.Added[0].Info != "您的快件正在派送中" {
+		t.Fatalf("unexpected added %+v", change.Added)
+	}
+	traces := change.Shipment.Traces
+	if len(traces) != 3 || traces[0].Info != "您的快件正在派送中" || traces[2].Info != "您的快件已到达【西安灞桥分拣中心】" {
+		t.Fatalf("unexpected traces %+v", traces)
+	}
+	if change.Shipment.LastTraceInfo != "您的快件正在派送中" {
+		t.Errorf("unexpected last trace %s", change.Shipment.LastTraceInfo)
+	}
+}
+
+// TestMerge_Stale 服务商重推的旧推送不会使状态回退或离开终态
+func TestMerge_Stale(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	delivered := &SubscribeRes{
+		OrderId: 33334,
+		Number:  "JD0076810087472",
+		Status:  StatusDelivered,
+		Traces: []Trace{
+			trace(t, "2022-06-30 10:34:52", "您的快件已由快递驿站代收"),
+			trace(t, "2022-06-29 22:30:20", "您的快件已发车"),
+		},
+	}
+	if _, err := Merge(ctx, store, delivered); err != nil {
+		t.Fatal(err)
+	}
+	change, err := Merge(ctx, store, &SubscribeRes{
+		OrderId: 33334,
+		Number:  "JD0076810087472",
+		Status:  StatusInTransit,
+		Traces:  []Trace{trace(t, "2022-06-29 22:30:20", "您的快件已发车")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.StatusChanged() || change.To != StatusDelivered || len(change.Added) != 0 || change.Shipment.Sequence != 1 {
+		t.Fatalf("unexpected change %+v", change)
+	}
+	if active, _ := store.ListActive(ctx); len(active) != 0 {
+		t.Fatalf("delivered shipment listed as active")
+	}
+
+	store = NewMemoryStore()
+	if _, err := Merge(ctx, store, &SubscribeRes{
+		OrderId: 33334,
+		Number:  "JD0076810087472",
+		Status:  StatusDelivering,
+		Traces:  []Trace{trace(t, "2022-06-30 08:12:00", "您的快件正在派送中")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	change, err = Merge(ctx, store, &SubscribeRes{
+		OrderId: 33334,
+		Number:  "JD0076810087472",
+		Status:  StatusInTransit,
+		Traces:  []Trace{trace(t, "2022-06-29 22:30:20", "您的快件已发车")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.To != StatusDelivering || len(change.Added) != 1 {
+		t.Fatalf("unexpected change %+v", change)
+	}
+}
+
+// plainStore 未实现 Updater 的 Store
+type plainStore struct {
+	Store
+}
+
+// TestMerge_Concurrent 同一运单并发合并时各次推送的轨迹都被保留
+func TestMerge_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range map[string]Store{
+		"updater": NewMemoryStore(),
+		"plain":   plainStore{NewMemoryStore()},
+	} {
+		base := time.Date(2022, 6, 29, 0, 0, 0, 0, DefaultTimeZone)
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				tm := localTime.Time(base.Add(time.Duration(i) * time.Hour))
+				_, err := Merge(ctx, store, &SubscribeRes{
+					OrderId: 33334,
+					Number:  "JD0076810087472",
+					Status:  StatusInTransit,
+					Traces:  []Trace{{Time: &tm, Info: fmt.Sprintf("轨迹%d", i)}},
+				})
+				if err != nil {
+					t.Error(err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		res, _ := store.Load(ctx, 33334)
+		if res == nil || len(res.Traces) != 20 {
+			t.Errorf("%s: unexpected shipment %+v", name, res)
+		}
+	}
+}
+
+// TestFileStore_WriteFailure 写入文件失败时内存中的运单不变，重试时仍能合并出变化
+func TestFileStore_WriteFailure(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "data")
+	store, err := NewFileStore(filepath.Join(dir, "shipments.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := &SubscribeRes{
+		OrderId: 33334,
+		Number:  "JD0076810087472",
+		Status:  StatusInTransit,
+		Traces:  []Trace{trace(t, "2022-06-29 22:30:20", "您的快件已发车")},
+	}
+	if _, err := Merge(ctx, store, res); err == nil {
+		t.Fatal("expected write error")
+	}
+	if saved, _ := store.Load(ctx, 33334); saved != nil {
+		t.Fatalf("saved after write error: %+v", saved)
+	}
+	if err := store.Ping(ctx); err == nil {
+		t.Error("expected ping error")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	change, err := Merge(ctx, store, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(change.Added) != 1 || !change.StatusChanged() {
+		t.Fatalf("unexpected change %+v", change)
+	}
+	if err := store.Ping(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestFileStore_TimeZone 运行环境时区不是北京时间时，Normalize 设置的时区经 FileStore 保存与重新加载后保持不变
+func TestFileStore_TimeZone(t *testing.T) {
+	defer func(loc *time.Location) {
+		time.Local = loc
+	}(time.Local)
+	time.Local = time.UTC
+
+	ctx := context.Background()
+	res := &SubscribeRes{
+		OrderId: 33334,
+		Number:  "JD0076810087472",
+		Status:  StatusDelivered,
+		Traces: []Trace{
+			trace(t, "2022-06-30 10:34:52", "您的快件已由快递驿站代收"),
+			trace(t, "2022-06-29 22:30:20", "您的快件已发车"),
+		},
+	}
+	res.UpdateTime = res.Traces[0].Time
+	Normalize(res, nil)
+	want := time.Time(*res.LastTraceTime)
+
+	store, err := NewFileStore(filepath.Join(t.TempDir(), "shipments.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(ctx, res); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewFileStore(store.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := reopened.Load(ctx, 33334)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, v := range map[string]*localTime.Time{
+		"lastTraceTime": saved.LastTraceTime,
+		"updateTime":    saved.UpdateTime,
+		"traces[0]":     saved.Traces[0].Time,
+	} {
+		if v == nil || !time.Time(*v).Equal(want) || v.String() != "2022-06-30 10:34:52" {
+			t.Errorf("%s: got %v, want %v", name, v, want)
+		}
+	}
+
+	//旧版本保存的不带时区的时间按 DefaultTimeZone 解析
+	var legacy storedShipment
+	if err := json.Unmarshal([]byte(`{"orderId":"1","lastTraceTime":"2022-06-30 10:34:52","traces":[{"time":"2022-06-30 10:34:52"}]}`), &legacy); err != nil {
+		t.Fatal(err)
+	}
+	if !time.Time(*legacy.LastTraceTime).Equal(want) || !time.Time(*legacy.Traces[0].Time).Equal(want) {
+		t.Errorf("legacy time %v", legacy.LastTraceTime)
+	}
+
+	//对外的JSON保持 localTime.Layout 格式，可解析为 localTime.Time
+	body, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var public struct {
+		LastTraceTime localTime.Time `json:"lastTraceTime"`
+		Traces        []struct {
+			Time localTime.Time `json:"time"`
+		} `json:"traces"`
+	}
+	if err := json.Unmarshal(body, &public); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	if public.LastTraceTime.String() != "2022-06-30 10:34:52" || public.Traces[0].Time.String() != "2022-06-30 10:34:52" {
+		t.Errorf("unexpected public json %s", body)
+	}
+}
@@ -0,0 +1,55 @@
+package expressTrace
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var facilityPattern = regexp.MustCompile(`【([^【】]+)】`)
+
+// TraceFacility 提取轨迹中第一个【】内的网点名称，如 "您的快件已到达【西安兴善营业部】"
+func TraceFacility(info string) string {
+	m := facilityPattern.FindStringSubmatch(info)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+var takeTimePattern = regexp.MustCompile(`^\s*(?:(\d+)天)?\s*(?:(\d+)小时)?\s*(?:(\d+)分钟?)?\s*(?:(\d+)秒)?\s*$`)
+
+// ParseTakeTime 解析 "0天18小时56分" 格式的耗时
+func ParseTakeTime(s string) (time.Duration, bool) {
+	m := takeTimePattern.FindStringSubmatch(s)
+	if m == nil || m[1]+m[2]+m[3]+m[4] == "" {
+		return 0, false
+	}
+	var units = []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var d time.Duration
+	for i, unit := range units {
+		if m[i+1] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return 0, false
+		}
+		d += time.Duration(n) * unit
+	}
+	return d, true
+}
+
+// FillTiming 服务商未返回更新时间与耗时时，按轨迹补全：更新时间取最新轨迹时间，签收后耗时取首末轨迹间隔
+func FillTiming(res *SubscribeRes) {
+	if res.UpdateTime == nil {
+		res.UpdateTime = res.LastTraceTime
+	}
+	if res.TakeTime != 0 || res.Status != StatusDelivered || len(res.Traces) < 2 {
+		return
+	}
+	first, last := res.Traces[len(res.Traces)-1].Time, res.Traces[0].Time
+	if first != nil && last != nil && last.After(*first) {
+		res.TakeTime = last.Sub(*first)
+	}
+}